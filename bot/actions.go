@@ -2,6 +2,7 @@ package bot
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"strconv"
@@ -15,7 +16,7 @@ import (
 
 const notFoundText = "Command not found"
 
-const helpText = "**Yeetbot**\n" +
+const helpHeader = "**Yeetbot**\n" +
 	"This bot yeets inactive users from your server, the following commands allow you to modify this behaviour.\n" +
 	"Activity is based on message creation and on voice state events (joining voice channel, moving, leaving, etc.).\n" +
 	"The bot will warn you on the halfway mark as well as the final day before you get kicked\n" +
@@ -23,31 +24,85 @@ const helpText = "**Yeetbot**\n" +
 	"**Syntax**\n" +
 	"!yeet <command> <args...>\n" +
 	"\n" +
-	"**Commands**\n" +
-	"```\n" +
-	" - help               | Shows this help dialog\n" +
-	" - timeout            | Gets the timeout (in days) before a user gets kicked\n" +
-	" - timeout (days)     | Sets the timeout (in days) before a user gets kicked\n" +
-	" - warntimeout (days) | Sets the timeout (in days) before a user gets warned, set to -1 to show the warning at the halfway mark\n" +
-	" - warntimeout        | Gets the timeout (in days) before a user gets warned\n" +
-	" - kickmsg            | Gets the message displayed when a user gets kicked\n" +
-	" - kickmsg (msg)      | Sets the message displayed when a user gets kicked\n" +
-	" - warnmsg            | Gets the message displayed when a user gets warned\n" +
-	" - warnmsg (msg)      | Sets the message displayed when a user gets warned\n" +
-	" - isimmune (mention) | Gets the user's immunity to being kicked\n" +
-	" - immune (mention)   | Toggles the user's immunity to being kicked\n" +
-	" - forceadd           | Forces all users (that make sense) to be added to yeetbots internal timing list\n" +
-	" - (mention)          | Forcefully yeets that person with a dumb message, you evil tater\n" +
-	"```\n" +
-	"**Bot written with <3 by Clipsey**\nSource: <https://github.com/Member1221/yeetbot>"
+	"**Commands**\n"
+
+var helpCommands = []string{
+	" - help               | Shows this help dialog",
+	" - timeout            | Gets the timeout (in days) before a user gets kicked",
+	" - timeout (days)     | Sets the timeout (in days) before a user gets kicked",
+	" - warntimeout (days) | Sets the timeout (in days) before a user gets warned, set to -1 to show the warning at the halfway mark",
+	" - warntimeout        | Gets the timeout (in days) before a user gets warned",
+	" - kickmsg            | Gets the message displayed when a user gets kicked",
+	" - kickmsg (msg)      | Sets the message displayed when a user gets kicked",
+	" - warnmsg            | Gets the message displayed when a user gets warned",
+	" - warnmsg (msg)      | Sets the message displayed when a user gets warned",
+	" - isimmune (mention) | Gets the user's immunity to being kicked",
+	" - me                 | Lets anyone on the server get a DM with when they were last active and how many days they have left",
+	" - status (mention)   | Shows the user's activity, warnings, immunity, when they'd be kicked and their kick history",
+	" - immune (mention)   | Toggles the user's immunity to being kicked",
+	" - forceadd           | Forces all users (that make sense) to be added to yeetbots internal timing list",
+	" - restore            | Gets whether roles and nicknames are restored when a kicked user rejoins",
+	" - restore (on/off)   | Sets whether roles and nicknames are restored when a kicked user rejoins",
+	" - restoredays        | Gets how many days after a kick the roles are restored",
+	" - restoredays (days) | Sets how many days after a kick the roles are restored",
+	" - undo               | Sends an invite to everyone kicked in the last automated run and restores their roles when they rejoin",
+	" - logchannel         | Gets the channel the bot reports to, the owner gets a DM if there is none",
+	" - logchannel (#chan) | Sets the channel the bot reports to, use off to DM the owner instead",
+	" - kicklimit          | Gets the maximum amount of users that can be kicked in one run",
+	" - kicklimit (users)  | Sets the maximum amount of users that can be kicked in one run, 0 disables the limit",
+	" - kickpercent        | Gets the maximum percentage of members that can be kicked in one run",
+	" - kickpercent (pct)  | Sets the maximum percentage of members that can be kicked in one run, 0 disables the limit",
+	" - export [csv]       | Attaches a file with the settings and every tracked user of this server, csv only has the users",
+	" - import [replace]   | Imports an attached export or CSV file, replace overwrites activity that's newer than the file",
+	" - report [days]      | Lists the members inactive for at least the given days with when they'll be kicked, as a file too",
+	" - run [--dry]        | Checks for inactive users right away unless paused, --dry only reports who would be warned and kicked",
+	" - confirm            | Kicks everyone from a run that was stopped by the kick limit",
+	" - review             | Lists the users waiting for review before being kicked",
+	" - review (on/off)    | Sets whether inactive users are queued for review instead of being kicked right away",
+	" - review approve (mention/all) [confirm] | Kicks a user (or everyone) waiting for review, confirm goes over the kick limit",
+	" - review deny (mention) [days] | Keeps a user, resetting their activity or making them immune for the given days",
+	" - backfill [days]    | Sets everyone's activity to their last message in the given days, defaults to the kick timeout",
+	" - activity           | Lists what counts as activity and how much each source weighs",
+	" - activity (source) (on/off/weight) | Sets whether and how much a source counts as activity, 0 or off disables it",
+	" - score              | Gets the activity score policy of this server",
+	" - score (on/off)     | Sets whether users with a low activity score get warned and kicked as well",
+	" - score (min) (days) | Sets the minimum activity score users need over the given days",
+	" - voiceminutes       | Gets how many minutes someone has to be in voice for it to count as activity",
+	" - voiceminutes (min) | Sets how many minutes someone has to be in voice for it to count as activity",
+	" - ignorechannel      | Lists the channels and categories that don't count as activity",
+	" - ignorechannel (#chan) | Toggles whether a channel or category counts as activity",
+	" - channelmode (allow/exclude) | Sets whether the listed channels are the only ones that count or the ones that don't",
+	" - quality            | Lists the rules messages have to pass to count as activity",
+	" - quality minlength (chars) | Sets how long messages have to be to count as activity",
+	" - quality (emoji/repeat/duplicate) (on/off) | Sets whether emoji only, repeated word or duplicate messages are ignored",
+	" - pause [duration]   | Stops automated warnings and kicks, for a duration like 3d or 12h or until resumed",
+	" - resume             | Starts automated warnings and kicks again",
+	" - schedule           | Gets the time of day and the timezone kicks happen in",
+	" - schedule (HH:MM/any) [timezone] | Sets the time of day kicks happen at and the timezone days are counted in, like 18:00 Europe/Berlin",
+	" - archivedthreads    | Gets whether activity in archived threads counts",
+	" - archivedthreads (on/off) | Sets whether activity in archived threads counts",
+	" - (mention)          | Forcefully yeets that person with a dumb message, you evil tater",
+}
+
+const helpFooter = "**Bot written with <3 by Clipsey**\nSource: <https://github.com/Member1221/yeetbot>"
+
+// Discord refuses messages longer than this
+const maxMessageLength = 2000
 
 const cmdTag = "!yeet"
 
+const undoMessage = "**Sorry! You were kicked from %server% by mistake, you're welcome to come back: %invite%**"
+
+const scoreWarningMessage = "**You will be kicked from %server% with the next daily check because you have been too inactive over the last %time% days, unless you display some more activity.**"
+
+// How many days a warning about a low activity score is good for, older ones don't count before a kick
+const scoreWarningDays = 7
+
 func UpdateServerCount(session *discord.Session) {
 	serverCount := MongoClient.CountServers()
 
 	// Set game playing, discard any errors
-	err := session.UpdateStatus(0, fmt.Sprint("Yeeting on ", serverCount, " servers..."))
+	err := session.UpdateGameStatus(0, fmt.Sprint("Yeeting on ", serverCount, " servers..."))
 
 	if err != nil {
 		log.Println(err)
@@ -56,91 +111,359 @@ func UpdateServerCount(session *discord.Session) {
 
 func HandleKickForGuild(session *discord.Session, guild *discord.Guild, guildData GuildData) {
 
-	unixDay := int64(24 * time.Hour.Seconds())
-	currentDay := time.Now().Unix() / unixDay
-	lastUpdated := guildData.LastUpdated.Unix() / unixDay
+	now := time.Now()
+
+	// Nothing automated happens while the bot or the guild is paused, activity still gets recorded
+	if InMaintenance() || guildData.IsPaused(now) {
+		return
+	}
+
+	// Don't update the server multiple times a day, days go by the guild's timezone
+	if guildData.Day(now) == guildData.Day(guildData.LastUpdated) {
+		return
+	}
 
-	// Don't update the server multiple times a day
-	if currentDay == lastUpdated {
+	// Wait for the time of day the guild wants kicks to happen at
+	if !guildData.IsRunTime(now) {
 		return
 	}
 
 	guildData.UpdateLastUpdated(time.Now().UTC())
 
+	report, err := runKickForGuild(session, guild, &guildData, false, false)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	log.Println("Checked", guild.ID, "-", report.Checked, "users,", report.Warned, "warned,", report.Kicked, "kicked,", report.Errored, "errors...")
+}
+
+type inactiveUser struct {
+	user      UserData
+	dayOffset int64
+	reason    string
+
+	// The user is warned or kicked for their activity score instead of the timeout
+	score bool
+}
+
+// Finds the users that should be warned and the users that should be kicked today
+// The users that got checked, were immune or couldn't be read are counted in the report
+func findInactiveUsers(guild *discord.Guild, guildData *GuildData, report *RunReport) ([]inactiveUser, []inactiveUser, error) {
+	var warnings []inactiveUser
+	var kicks []inactiveUser
+
+	// Make sure nobody gets kicked for activity that's still waiting to be written
+	err := FlushActivity()
+	if err != nil {
+		log.Println(err)
+	}
+
+	// Inactivity is counted in the guild's days
+	currentDay := guildData.Day(time.Now())
+
+	// Get everyone's activity score if the guild kicks based on it, scores are stored per UTC day
+	var scores map[string]float64
+	scoreActive := guildData.ScorePolicyActive(time.Now())
+	if scoreActive {
+		windowScores, err := GetActivityScores(guild.ID, dayOf(time.Now().UTC())-guildData.ScoreWindow+1)
+		if err != nil {
+			return nil, nil, err
+		}
+		scores = windowScores
+	}
+	windowStart := time.Now().UTC().AddDate(0, 0, -int(guildData.ScoreWindow))
+
 	// Create a cursor over all the users on a server
 	cur, err := MongoClient.UsersCollection().Find(context.Background(), bson.D{{"guildId", guild.ID}})
 	if err != nil {
-		log.Println(err)
-	} else {
-		defer cur.Close(context.Background())
+		return nil, nil, err
+	}
+	defer cur.Close(context.Background())
 
-		// No errors, iterate over all servers
-		for cur.Next(context.Background()) {
+	// No errors, iterate over all users
+	for cur.Next(context.Background()) {
 
-			var result UserData
-			err := cur.Decode(&result)
-			if err != nil {
-				log.Println(err)
-				break
-			}
+		var result UserData
+		err := cur.Decode(&result)
+		if err != nil {
+			log.Println(err)
+			report.Errored++
+			continue
+		}
+		report.Checked++
 
-			// Skip users whom are immune
-			if result.Immune {
-				continue
-			}
+		// Skip users whom are immune
+		if result.IsImmune(time.Now()) {
+			report.Immune++
+			continue
+		}
 
-			// Skip the owner of the server
-			if result.UserId == guild.OwnerID {
-				continue
-			}
+		// Skip the owner of the server
+		if result.UserId == guild.OwnerID {
+			report.Immune++
+			continue
+		}
 
-			// The bot really shouldn't be here, we'll delete it
-			if result.UserId == SelfId {
+		// The bot really shouldn't be here, we'll delete it unless nothing is supposed to change
+		if result.UserId == SelfId {
+			if !report.Dry {
 				DeleteUser(result.GuildId, result.UserId)
-				continue
 			}
+			continue
+		}
 
-			lastActivity := result.LastActivity.Unix() / unixDay
+		lastActivity := guildData.Day(result.LastActivity)
 
-			// Calculate and check day offsets
-			dayOffset := currentDay - lastActivity
-			halfwayMark := guildData.MaxDayInactivity / 2
-			lastDay := guildData.MaxDayInactivity - 1
+		// Calculate and check day offsets
+		dayOffset := currentDay - lastActivity
+		halfwayMark, lastDay := guildData.WarningDays()
 
-			// If the admin has specified a day offset for the warning use that instead
-			if guildData.FirstWarnOffset >= 5 {
-				halfwayMark = guildData.FirstWarnOffset
+		// Send warning messages at the halfway mark as well as the last day
+		if dayOffset == halfwayMark || dayOffset == lastDay {
+			warnings = append(warnings, inactiveUser{result, dayOffset, "", false})
+			continue
+		}
+
+		// After time's up kick the user, but only if they got warned on an earlier day
+		// A pause or downtime can cover the warning days, those users get warned now and kicked by a later run
+		if dayOffset > guildData.MaxDayInactivity {
+			switch {
+			case !result.LastWarned.After(result.LastActivity):
+				warnings = append(warnings, inactiveUser{result, dayOffset, "", false})
+			case guildData.Day(result.LastWarned) < currentDay:
+				kicks = append(kicks, inactiveUser{result, dayOffset, kickReason(guildData), false})
 			}
+			continue
+		}
 
-			// Send warning messages at the halfway mark as well as the last day
-			if dayOffset == halfwayMark || dayOffset == lastDay {
-				channel, err := session.UserChannelCreate(result.UserId)
-				if err == nil {
-					timeRepl := strings.ReplaceAll(guildData.WarningMessage, "%time%", fmt.Sprint(guildData.MaxDayInactivity-dayOffset))
-					serverRepl := strings.ReplaceAll(timeRepl, "%server%", guild.Name)
+		// Users that haven't been around for the whole window can't have a fair score yet
+		if scoreActive && !result.TrackedSince.After(windowStart) && scores[result.UserId] < guildData.ScoreThreshold {
 
-					session.ChannelMessageSend(channel.ID, serverRepl)
-					continue
-				}
+			// Users get warned first and are kicked by a later run if their score is still too low
+			warnedDay := guildData.Day(result.ScoreWarned)
+			switch {
+			case warnedDay == currentDay:
+				continue
+			case warnedDay < currentDay-scoreWarningDays:
+				warnings = append(warnings, inactiveUser{result, dayOffset, "", true})
+			default:
+				kicks = append(kicks, inactiveUser{result, dayOffset, scoreKickReason(guildData), true})
 			}
+		}
+	}
+	return warnings, kicks, nil
+}
+
+// Works out who a run warns, queues for review and kicks, dry runs and real runs both go through here so they agree
+// The counts of a dry run are filled in, a capped run neither warns nor kicks anyone
+func planRun(guild *discord.Guild, guildData *GuildData, force bool, report *RunReport) ([]inactiveUser, []inactiveUser, []inactiveUser, error) {
+	var queued []inactiveUser
+
+	warnings, kicks, err := findInactiveUsers(guild, guildData, report)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// Queued users aren't kicked by the run, so they don't count towards the safety cap
+	if guildData.ReviewKicks {
+		queued = kicks
+		kicks = nil
+		report.Queued = len(queued)
+	}
+
+	report.Capped = !force && guildData.KickLimitExceeded(len(kicks), guild.MemberCount)
+	if report.Dry && !report.Capped {
+		report.Warned = len(warnings)
+		report.Kicked = len(kicks)
+	}
+	return warnings, queued, kicks, nil
+}
+
+// Warns and kicks the inactive users of a guild, a dry run only counts who would be warned and kicked
+func runKickForGuild(session *discord.Session, guild *discord.Guild, guildData *GuildData, force, dry bool) (RunReport, error) {
+	report := RunReport{Dry: dry}
+
+	// Two runs at once would warn and kick everyone twice
+	if !lockGuildRun(guild.ID) {
+		return report, errors.New("A run for this server is already in progress")
+	}
+	defer unlockGuildRun(guild.ID)
+
+	warnings, queued, kicks, err := planRun(guild, guildData, force, &report)
+	if err != nil {
+		return report, err
+	}
+
+	if dry {
+		return report, nil
+	}
+
+	// In review mode the admins decide who gets kicked
+	if len(queued) > 0 {
+		queueForReview(session, guild, guildData, queued)
+	}
 
-			// After time's up kick the user
-			if dayOffset > guildData.MaxDayInactivity {
+	// If activity tracking broke we'd kick way too many people, let an admin decide instead
+	if report.Capped {
+		log.Println("Kick limit exceeded for", guild.ID, "...")
 
-				timeRepl := strings.ReplaceAll(guildData.KickMessage, "%time%", strconv.FormatInt(guildData.MaxDayInactivity, 10))
-				serverRepl := strings.ReplaceAll(timeRepl, "%server%", guild.Name)
+		err := guildData.SetAwaitingConfirm(true)
+		if err != nil {
+			log.Println(err)
+		}
+
+		logToGuild(session, guild, guildData, fmt.Sprint("**Safety cap reached: ", len(kicks), " of ", guild.MemberCount, " members would have been kicked ",
+			"(limit is ", guildData.MaxKicksPerRun, " users or ", guildData.MaxKickPercent, "%). No one was kicked.**\n",
+			"Run `", cmdTag, " confirm` to kick them anyways."))
+		return report, nil
+	}
+
+	if guildData.AwaitingConfirm {
+		err := guildData.SetAwaitingConfirm(false)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
+	for _, warning := range warnings {
+
+		// Users that can't be messaged count as warned too, otherwise closed DMs would keep them from ever getting kicked
+		err := warning.user.UpdateLastWarned(time.Now().UTC())
+		if err != nil {
+			log.Println(err)
+		}
 
-				// Do the yeetin'
-				yeet(session, result.GuildId, result.UserId, serverRepl, fmt.Sprintln("Inactivity for over ", guildData.MaxDayInactivity, " days. (Automated)"))
+		if warning.score {
+			err = warning.user.UpdateScoreWarned(time.Now().UTC())
+			if err != nil {
+				log.Println(err)
 			}
+		}
+
+		channel, err := session.UserChannelCreate(warning.user.UserId)
+		if err != nil {
+			report.Errored++
+			continue
+		}
+
+		// Users warned late get kicked by the next run
+		daysLeft := guildData.MaxDayInactivity - warning.dayOffset
+		if daysLeft < 1 {
+			daysLeft = 1
+		}
+
+		timeRepl := strings.ReplaceAll(guildData.WarningMessage, "%time%", fmt.Sprint(daysLeft))
+		if warning.score {
+			timeRepl = strings.ReplaceAll(scoreWarningMessage, "%time%", fmt.Sprint(guildData.ScoreWindow))
+		}
+		serverRepl := strings.ReplaceAll(timeRepl, "%server%", guild.Name)
+
+		_, err = session.ChannelMessageSend(channel.ID, serverRepl)
+		if err != nil {
+			report.Errored++
+			continue
+		}
+		report.Warned++
+	}
+
+	// Every automated kick from this run is recorded under the same batch so it can be undone
+	batchId := newBatchId()
+	kicked := 0
+
+	for _, kick := range kicks {
+
+		// Do the yeetin'
+		err := yeet(session, kick.user.GuildId, kick.user.UserId, kickMessage(guild, guildData), kick.reason, batchId)
+		if err != nil {
+			report.Errored++
+			continue
+		}
+		kicked++
+	}
+	report.Kicked = kicked
+
+	// Remember the batch so that it can be undone
+	if kicked > 0 {
+		err := guildData.SetLastBatch(batchId)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+	return report, nil
+}
+
+func kickMessage(guild *discord.Guild, guildData *GuildData) string {
+	timeRepl := strings.ReplaceAll(guildData.KickMessage, "%time%", strconv.FormatInt(guildData.MaxDayInactivity, 10))
+	return strings.ReplaceAll(timeRepl, "%server%", guild.Name)
+}
+
+func kickReason(guildData *GuildData) string {
+	return fmt.Sprintln("Inactivity for over ", guildData.MaxDayInactivity, " days. (Automated)")
+}
 
+func scoreKickReason(guildData *GuildData) string {
+	return fmt.Sprintln("Activity score below ", guildData.ScoreThreshold, " over ", guildData.ScoreWindow, " days. (Automated)")
+}
+
+// Sends a message to the guild's log channel, or to the owner if there is none
+func logToGuild(session *discord.Session, guild *discord.Guild, guildData *GuildData, message string) {
+	channelId := guildData.LogChannel
+
+	if channelId == "" {
+		channel, err := session.UserChannelCreate(guild.OwnerID)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		channelId = channel.ID
+	}
+
+	_, err := sendQuiet(session, channelId, message)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// Sends a message without pinging anyone mentioned in it
+func sendQuiet(session *discord.Session, channelId, message string) (*discord.Message, error) {
+	return session.ChannelMessageSendComplex(channelId, &discord.MessageSend{
+		Content:         message,
+		AllowedMentions: &discord.MessageAllowedMentions{},
+	})
+}
+
+// Joins lines into as few messages as possible without going over the message limit
+func chunkLines(lines []string) []string {
+	var messages []string
+	message := ""
+
+	for _, line := range lines {
+		if message != "" && len(message)+len(line)+1 > maxMessageLength {
+			messages = append(messages, message)
+			message = ""
 		}
+		message += line + "\n"
+	}
+
+	if message != "" {
+		messages = append(messages, message)
 	}
+	return messages
 }
 
-func yeet(session *discord.Session, guildId, userId, message, reason string) {
+func yeet(session *discord.Session, guildId, userId, message, reason, batchId string) error {
 	log.Println(fmt.Sprint("Yeeting ", userId, " due to inactivity..."))
 
+	// Snapshot the member's roles and nickname before they are gone
+	var roles []string
+	var nick string
+	member, err := session.GuildMember(guildId, userId)
+	if err == nil {
+		roles = member.Roles
+		nick = member.Nick
+	}
+
 	// Tell the user that they have been kicked
 	channel, err := session.UserChannelCreate(userId)
 	if err == nil {
@@ -149,73 +472,194 @@ func yeet(session *discord.Session, guildId, userId, message, reason string) {
 
 	// Proceed to kick the user and add a reason for the audit log
 	err = session.GuildMemberDeleteWithReason(guildId, userId, reason)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	// Record the kick so the snapshot can be restored on rejoin
+	// Automated kicks carry a batch id so that the whole batch can be undone later
+	err = CreateKickRecord(KickRecord{
+		GuildId:   guildId,
+		UserId:    userId,
+		BatchId:   batchId,
+		Roles:     roles,
+		Nick:      nick,
+		KickedAt:  time.Now().UTC(),
+		Automated: batchId != "",
+	})
 	if err != nil {
 		log.Println(err)
 	}
+	return nil
 }
 
 func HandleUserJoin(session *discord.Session, user *discord.GuildMemberAdd) {
 
-	// User does not exist, create them
-	err := CreateUser(user.GuildID, user.User.ID, time.Now().UTC())
+	// Activity from before the user left was thrown away, new activity counts again
+	forgetDeparture(user.GuildID, user.User.ID)
+
+	// Create the user, or start their activity over if they rejoined
+	err := JoinUser(user.GuildID, user.User.ID, time.Now().UTC())
 	if err != nil {
 
 		// Something bad happened?
 		log.Println(err)
 		return
 	}
+
+	// Give back the roles of users whose kick was undone
+	record, err := GetPendingRestore(user.GuildID, user.User.ID)
+	if err == nil {
+		restoreRoles(session, record)
+		return
+	}
+
+	guildData, err := GetGuild(user.GuildID)
+	if err != nil {
+		return
+	}
+
+	// Otherwise restore the snapshot if the guild wants it and the user came back soon enough
+	if guildData.RestoreRoles {
+		since := time.Now().UTC().AddDate(0, 0, -int(guildData.RestoreWindow))
+		record, err := GetLatestKick(user.GuildID, user.User.ID, since)
+		if err == nil {
+			restoreRoles(session, record)
+		}
+	}
+}
+
+func restoreRoles(session *discord.Session, record *KickRecord) {
+	for _, role := range record.Roles {
+
+		// Everyone gets the @everyone role anyways
+		if role == record.GuildId {
+			continue
+		}
+
+		err := session.GuildMemberRoleAdd(record.GuildId, record.UserId, role)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
+	if record.Nick != "" {
+		err := session.GuildMemberNickname(record.GuildId, record.UserId, record.Nick)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
+	err := record.MarkRestored()
+	if err != nil {
+		log.Println(err)
+	}
 }
 
 func HandleUserLeave(session *discord.Session, user *discord.GuildMemberRemove) {
 
+	// Buffered activity would bring the user back
+	discardActivity(user.GuildID, user.User.ID)
+	discardVoiceSession(user.GuildID, user.User.ID)
+
 	// Try to delete a user from the db, if it fails it's fine
 	_ = DeleteUser(user.GuildID, user.User.ID)
+	_ = DeletePendingKick(user.GuildID, user.User.ID)
+	_ = DeleteActivityForUser(user.GuildID, user.User.ID)
 }
 
 func HandleUserVoice(session *discord.Session, state *discord.VoiceStateUpdate) {
 
-	// Dont count the bot's activity
-	if state.UserID == SelfId {
+	// Get the guild
+	guild, err := GetDiscordGuild(session, state.GuildID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	// Get current UTC time
+	stamp := time.Now().UTC()
+
+	// Work out how long the user was really in voice before this update
+	minutes, previousChannel := updateVoiceSession(guild, state.VoiceState, stamp)
+	trackVoiceTime(session, guild, previousChannel, state.UserID, stamp, minutes)
+
+	// Only joining or moving between channels counts, toggling mute and the like doesn't
+	if state.ChannelID == "" || state.ChannelID == guild.AfkChannelID {
+		return
+	}
+
+	if state.BeforeUpdate != nil && state.BeforeUpdate.ChannelID == state.ChannelID {
+		return
+	}
+
+	// Stages count separately from regular voice channels
+	source := SourceVoice
+	channel, err := session.State.Channel(state.ChannelID)
+	if err == nil && channel.Type == discord.ChannelTypeGuildStageVoice {
+		source = SourceStage
+	}
+
+	trackActivity(session, guild, state.ChannelID, state.UserID, stamp, source, 1)
+}
+
+func HandleReaction(session *discord.Session, reaction *discord.MessageReactionAdd) {
+
+	// Reactions in DMs don't belong to any guild
+	if reaction.GuildID == "" {
 		return
 	}
 
 	// Get the guild
-	guild, err := session.Guild(state.GuildID)
+	guild, err := GetDiscordGuild(session, reaction.GuildID)
 	if err != nil {
 		log.Println(err)
 		return
 	}
 
-	// Don't count the owner's activity, they are automatically immune anyways
-	if state.UserID == guild.OwnerID {
+	trackActivity(session, guild, reaction.ChannelID, reaction.UserID, time.Now().UTC(), SourceReaction, 1)
+}
+
+func HandlePresence(session *discord.Session, presence *discord.PresenceUpdate) {
+
+	// Going offline isn't activity
+	if presence.User == nil || presence.Status == discord.StatusOffline {
 		return
 	}
 
-	// Get current UTC time
-	stamp := time.Now().UTC()
-
-	// Try to get the user
-	user, err := GetUser(state.GuildID, state.UserID)
+	// Get the guild
+	guild, err := GetDiscordGuild(session, presence.GuildID)
 	if err != nil {
+		log.Println(err)
+		return
+	}
 
-		// User does not exist, create them
-		err := CreateUser(state.GuildID, state.UserID, stamp)
-		if err != nil {
+	trackActivity(session, guild, "", presence.User.ID, time.Now().UTC(), SourcePresence, 1)
+}
 
-			// Something bad happened?
-			log.Println(err)
-			return
-		}
+func HandleThreadCreate(session *discord.Session, thread *discord.ThreadCreate) {
+
+	// Threads the bot gets added to or learns about later aren't new activity
+	if !thread.NewlyCreated || thread.OwnerID == "" {
+		return
+	}
 
+	// Get the guild
+	guild, err := GetDiscordGuild(session, thread.GuildID)
+	if err != nil {
+		log.Println(err)
 		return
 	}
 
-	// Update the user's activity
-	user.UpdateActivity(stamp)
+	trackActivity(session, guild, thread.ID, thread.OwnerID, time.Now().UTC(), SourceThread, 1)
 }
 
 func HandleSelfJoin(session *discord.Session, data *discord.GuildCreate) {
 
+	// Start timing everyone that's already in voice
+	openVoiceSessions(data.Guild)
+
 	// Make sure to reuse old guilds
 	_, err := GetGuild(data.Guild.ID)
 	if err != nil {
@@ -242,7 +686,10 @@ func HandleSelfLeave(session *discord.Session, data *discord.GuildDelete) {
 
 	// Delete the data associated with the guild
 	// We don't want to waste database space on it
+	discardGuildActivity(data.ID)
 	DeleteUsersForGuild(data.ID)
+	DeletePendingKicksForGuild(data.ID)
+	DeleteActivityForGuild(data.ID)
 	DeleteGuild(data.ID)
 
 	// Update the server count
@@ -264,7 +711,7 @@ func HandleMessage(session *discord.Session, data *discord.MessageCreate) {
 	}
 
 	// Get the guild
-	guild, err := session.Guild(data.GuildID)
+	guild, err := GetDiscordGuild(session, data.GuildID)
 	if err != nil {
 		log.Println(err)
 		return
@@ -290,14 +737,24 @@ func HandleMessage(session *discord.Session, data *discord.MessageCreate) {
 		// Otherwise update the user data for the message
 		// If the user isn't present in db they will be created
 		// The owner of the server is immune to this
-		if data.Author.ID != guild.OwnerID {
-			handleUpdateData(session, data)
-		}
+		handleUpdateData(session, data, guild)
 	}
 }
 
 func handleCommand(session *discord.Session, data *discord.MessageCreate, guild *discord.Guild) {
 
+	// Bot admins can switch maintenance mode from any server
+	if isBotAdmin(data.Author.ID) && strings.HasPrefix(data.Content, cmdTag+" maintenance") {
+		handleMaintenance(session, data, strings.Fields(data.Content)[2:])
+		return
+	}
+
+	// Anyone can check their own status
+	if strings.ToLower(strings.TrimSpace(data.Content)) == cmdTag+" me" {
+		handleMe(session, data, guild)
+		return
+	}
+
 	// Delete commands sent by unaothorized users
 	if data.Author.ID != guild.OwnerID {
 		log.Println(data.Author.ID, guild.OwnerID)
@@ -307,7 +764,7 @@ func handleCommand(session *discord.Session, data *discord.MessageCreate, guild
 
 	// Help text needed (for "!yeet")
 	if len(data.Content) < len(cmdTag)+1 {
-		session.ChannelMessageSend(data.ChannelID, helpText)
+		sendHelp(session, data.ChannelID)
 		return
 	}
 
@@ -316,7 +773,7 @@ func handleCommand(session *discord.Session, data *discord.MessageCreate, guild
 
 	// Help text
 	if len(command) == 0 || command[0] == "help" {
-		session.ChannelMessageSend(data.ChannelID, helpText)
+		sendHelp(session, data.ChannelID)
 		return
 	}
 
@@ -446,6 +903,10 @@ func handleCommand(session *discord.Session, data *discord.MessageCreate, guild
 		session.ChannelMessageSend(data.ChannelID, fmt.Sprint("User immunity is set to: ", guildUser.Immune))
 		break
 
+	case "status":
+		handleStatus(session, data, guild, guildData, command[1:])
+		break
+
 	case "immune":
 		if len(command) != 2 {
 			session.ChannelMessageDelete(data.ChannelID, data.ID)
@@ -459,11 +920,11 @@ func handleCommand(session *discord.Session, data *discord.MessageCreate, guild
 			return
 		}
 
-		// Get the guild user
+		// Get the guild user, users that aren't tracked yet get tracked from now on
 		guildUser, err := guildData.GetUser(member.User.ID)
 		if err != nil {
-			log.Println(err)
-			return
+			newUser := createUser(guild.ID, member.User.ID, time.Now().UTC(), SourceManual)
+			guildUser = &newUser
 		}
 
 		err = guildUser.UpdateImmunity(!guildUser.Immune)
@@ -477,63 +938,585 @@ func handleCommand(session *discord.Session, data *discord.MessageCreate, guild
 		break
 
 	case "forceadd":
-		guild, err := session.Guild(data.GuildID)
-		if err != nil {
-			log.Println(err)
-			return
-		}
-
 		session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**Force adding everyone...**"))
 		amount := handleForceAdd(session, guild)
 		session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**Done, added ", amount, " users...**"))
 		break
 
-	default:
-
-		member := mentionToMember(session, guild.ID, command[0])
-		if member == nil {
+	case "restore":
+		if len(command) == 1 {
+			session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**Restoring roles on rejoin is set to: ", guildData.RestoreRoles, " (within ", guildData.RestoreWindow, " days)**"))
+			return
+		}
 
-			// Alert the user that the command was not found
-			session.ChannelMessageSend(data.ChannelID, fmt.Sprint(command[0], ": ", notFoundText))
+		value, err := parseToggle(command[1])
+		if err != nil {
+			session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**", err.Error(), "**"))
+			break
+		}
+
+		err = guildData.SetRestoreRoles(value)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**Restoring roles on rejoin set to: ", guildData.RestoreRoles, "**"))
+		break
+
+	case "restoredays":
+		if len(command) == 1 {
+			session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**Roles are restored for users rejoining within ", guildData.RestoreWindow, " days**"))
+			return
+		}
+
+		value, err := strconv.ParseInt(command[1], 0, 64)
+		if err != nil {
+			session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**", err.Error(), "**"))
+			break
+		}
+
+		err = guildData.UpdateRestoreWindow(value)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**Roles will be restored for users rejoining within ", guildData.RestoreWindow, " days**"))
+		break
+
+	case "logchannel":
+		if len(command) == 1 {
+			if guildData.LogChannel == "" {
+				session.ChannelMessageSend(data.ChannelID, "**There is no log channel, the owner gets messaged instead**")
+				return
+			}
+
+			session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**The log channel for this server is <#", guildData.LogChannel, ">**"))
+			return
+		}
+
+		channelId := ""
+		if strings.ToLower(command[1]) != "off" {
+			channel := mentionToChannel(session, guild.ID, command[1])
+			if channel == nil {
+
+				// Channel was not found
+				session.ChannelMessageSend(data.ChannelID, "Channel not found")
+				return
+			}
+			channelId = channel.ID
+		}
+
+		err = guildData.SetLogChannel(channelId)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		if channelId == "" {
+			session.ChannelMessageSend(data.ChannelID, "**Log channel removed, the owner will be messaged instead**")
+			return
+		}
+		session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**Log channel set to <#", channelId, ">**"))
+		break
+
+	case "kicklimit":
+		if len(command) == 1 {
+			session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**At most ", guildData.MaxKicksPerRun, " users can be kicked in one run (0 means no limit)**"))
+			return
+		}
+
+		value, err := strconv.ParseInt(command[1], 0, 64)
+		if err != nil {
+			session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**", err.Error(), "**"))
+			break
+		}
+
+		err = guildData.UpdateKickLimit(value)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**Kick limit for this server set to ", guildData.MaxKicksPerRun, " users**"))
+		break
+
+	case "kickpercent":
+		if len(command) == 1 {
+			session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**At most ", guildData.MaxKickPercent, "% of members can be kicked in one run (0 means no limit)**"))
+			return
+		}
+
+		value, err := strconv.ParseInt(strings.TrimSuffix(command[1], "%"), 0, 64)
+		if err != nil {
+			session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**", err.Error(), "**"))
+			break
+		}
+
+		err = guildData.UpdateKickPercent(value)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**Kick limit for this server set to ", guildData.MaxKickPercent, "% of members**"))
+		break
+
+	case "confirm":
+		if !guildData.AwaitingConfirm {
+			session.ChannelMessageSend(data.ChannelID, "**There is no run waiting for confirmation**")
+			return
+		}
+
+		// The run stays waiting for confirmation until kicks can happen again
+		if stopped := kicksStopped(guildData, time.Now()); stopped != "" {
+			session.ChannelMessageSend(data.ChannelID, stopped)
+			return
+		}
+
+		session.ChannelMessageSend(data.ChannelID, "**Confirmed, yeeting...**")
+		report, err := runKickForGuild(session, guild, guildData, true, false)
+		if err != nil {
+			session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**", err.Error(), "**"))
+			return
+		}
+		session.ChannelMessageSend(data.ChannelID, report.String())
+		break
+
+	case "export":
+		handleExport(session, data, guild, command[1:])
+		break
+
+	case "import":
+		handleImport(session, data, guild, guildData, command[1:])
+		break
+
+	case "report":
+		handleReport(session, data, guild, guildData, command[1:])
+		break
+
+	case "run":
+		dry := len(command) > 1 && command[1] == "--dry"
+
+		// Runs are stopped for a reason, only checking who would be kicked is fine
+		if !dry && InMaintenance() {
+			session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**The bot is in maintenance mode, runs are stopped until it's over. Use `", cmdTag, " run --dry` to check who would be kicked**"))
+			return
+		}
+		if !dry && guildData.IsPaused(time.Now()) {
+			session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**This server is paused, use `", cmdTag, " resume` first or `", cmdTag, " run --dry` to check who would be kicked**"))
+			return
+		}
+
+		if dry {
+			session.ChannelMessageSend(data.ChannelID, "**Checking who would be warned and kicked...**")
+		} else {
+			session.ChannelMessageSend(data.ChannelID, "**Running now...**")
+		}
+
+		report, err := runKickForGuild(session, guild, guildData, false, dry)
+		if err != nil {
+			session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**", err.Error(), "**"))
+			return
+		}
+
+		// The scheduled run doesn't have to warn everyone again today
+		if !dry {
+			err = guildData.UpdateLastUpdated(time.Now().UTC())
+			if err != nil {
+				log.Println(err)
+			}
+		}
+		session.ChannelMessageSend(data.ChannelID, report.String())
+		break
+
+	case "review":
+		handleReview(session, data, guild, guildData, command[1:])
+		break
+
+	case "backfill":
+		days := guildData.MaxDayInactivity
+		if len(command) > 1 {
+			value, err := strconv.ParseInt(command[1], 0, 64)
+			if err != nil {
+				session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**", err.Error(), "**"))
+				return
+			}
+			days = value
+		}
+
+		// Over a year is a bit long
+		if days < 1 || days > 365 {
+			session.ChannelMessageSend(data.ChannelID, "**Days have to be between 1 and 365**")
+			return
+		}
+
+		// Reading through message history takes a while, don't block other events
+		go func() {
+			session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**Reading the last ", days, " days of messages, this can take a while...**"))
+			updated, scanned, err := BackfillGuild(session, guild, time.Now().UTC().AddDate(0, 0, -int(days)))
+			if err != nil {
+				session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**", err.Error(), "**"))
+				return
+			}
+			session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**Done, updated ", updated, " users from ", scanned, " messages...**"))
+		}()
+		break
+
+	case "activity":
+		if len(command) == 1 {
+			lines := []string{"**Activity sources for this server:**"}
+			for _, source := range ActivitySources {
+				lines = append(lines, fmt.Sprint(" - ", source, ": ", guildData.ActivityWeight(source)))
+			}
+			session.ChannelMessageSend(data.ChannelID, strings.Join(lines, "\n"))
+			return
+		}
+
+		if len(command) != 3 || !isActivitySource(command[1]) {
+			session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**Usage: ", cmdTag, " activity (", strings.Join(ActivitySources, "/"), ") (on/off/weight)**"))
+			return
+		}
+
+		// Either a toggle or a weight
+		weight := 1.0
+		value, err := parseToggle(command[2])
+		if err == nil {
+			if !value {
+				weight = 0
+			}
+		} else {
+			weight, err = strconv.ParseFloat(command[2], 64)
+			if err != nil {
+				session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**", err.Error(), "**"))
+				return
+			}
+		}
+
+		err = guildData.SetActivityWeight(strings.ToLower(command[1]), weight)
+		if err != nil {
+			session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**", err.Error(), "**"))
+			return
+		}
+		session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**Weight of ", strings.ToLower(command[1]), " activity set to ", guildData.ActivityWeight(strings.ToLower(command[1])), "**"))
+		break
+
+	case "score":
+		if len(command) == 1 {
+			session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**Kicking users with an activity score below ", guildData.ScoreThreshold,
+				" over ", guildData.ScoreWindow, " days is set to: ", guildData.ScorePolicy, "**"))
+			return
+		}
+
+		// Either a toggle or a threshold with a window
+		value, err := parseToggle(command[1])
+		if err == nil {
+			err = guildData.SetScorePolicy(value)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+		} else {
+			if len(command) != 3 {
+				session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**Usage: ", cmdTag, " score (on/off) or ", cmdTag, " score (threshold) (days)**"))
+				return
+			}
+
+			threshold, err := strconv.ParseFloat(command[1], 64)
+			if err != nil {
+				session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**", err.Error(), "**"))
+				return
+			}
+
+			days, err := strconv.ParseInt(command[2], 0, 64)
+			if err != nil {
+				session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**", err.Error(), "**"))
+				return
+			}
+
+			err = guildData.UpdateScorePolicy(threshold, days)
+			if err != nil {
+				session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**", err.Error(), "**"))
+				return
+			}
+		}
+
+		session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**Kicking users with an activity score below ", guildData.ScoreThreshold,
+			" over ", guildData.ScoreWindow, " days set to: ", guildData.ScorePolicy, "**"))
+		break
+
+	case "voiceminutes":
+		if len(command) == 1 {
+			session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**Time in voice counts as activity after ", guildData.MinVoiceMinutes, " minutes**"))
+			return
+		}
+
+		value, err := strconv.ParseInt(command[1], 0, 64)
+		if err != nil {
+			session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**", err.Error(), "**"))
+			break
+		}
+
+		err = guildData.UpdateMinVoiceMinutes(value)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**Time in voice now counts as activity after ", guildData.MinVoiceMinutes, " minutes**"))
+		break
+
+	case "ignorechannel":
+		if len(command) == 1 {
+			lines := []string{fmt.Sprint("**Channels and categories that ", channelListMeaning(guildData), ":**")}
+			for _, channelId := range guildData.IgnoredChannels {
+				lines = append(lines, fmt.Sprint(" - <#", channelId, ">"))
+			}
+			for _, categoryId := range guildData.IgnoredCategories {
+				lines = append(lines, fmt.Sprint(" - <#", categoryId, "> (category)"))
+			}
+			session.ChannelMessageSend(data.ChannelID, strings.Join(lines, "\n"))
+			return
+		}
+
+		channel := mentionToChannel(session, guild.ID, command[1])
+		if channel == nil {
+
+			// Channel was not found
+			session.ChannelMessageSend(data.ChannelID, "Channel not found")
+			return
+		}
+
+		listed, err := guildData.ToggleChannel(channel.ID, channel.Type == discord.ChannelTypeGuildCategory)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		if listed {
+			session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**<#", channel.ID, "> added to the channels that ", channelListMeaning(guildData), "**"))
+			return
+		}
+		session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**<#", channel.ID, "> removed from the channels that ", channelListMeaning(guildData), "**"))
+		break
+
+	case "channelmode":
+		if len(command) == 1 {
+			session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**The listed channels ", channelListMeaning(guildData), "**"))
+			return
+		}
+
+		switch strings.ToLower(command[1]) {
+		case "allow":
+			if len(guildData.IgnoredChannels) == 0 && len(guildData.IgnoredCategories) == 0 {
+				session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**Add the channels that should count with `", cmdTag, " ignorechannel (#chan)` first, otherwise no channel would count**"))
+				return
+			}
+			err = guildData.SetChannelAllowList(true)
+		case "exclude":
+			err = guildData.SetChannelAllowList(false)
+		default:
+			session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**Usage: ", cmdTag, " channelmode (allow/exclude)**"))
+			return
+		}
+
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**The listed channels now ", channelListMeaning(guildData), "**"))
+		break
+
+	case "quality":
+		if len(command) == 1 {
+			session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**Message rules for this server:**\n",
+				" - minimum length: ", guildData.MinMessageLength, "\n",
+				" - ignore emoji only: ", guildData.IgnoreEmojiOnly, "\n",
+				" - ignore repeated words: ", guildData.IgnoreRepeatedWord, "\n",
+				" - ignore duplicates: ", guildData.IgnoreDuplicates))
+			return
+		}
+
+		if len(command) != 3 {
+			session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**Usage: ", cmdTag, " quality (minlength/emoji/repeat/duplicate) (value)**"))
+			return
+		}
+
+		rule := strings.ToLower(command[1])
+		if rule == "minlength" {
+			value, err := strconv.ParseInt(command[2], 0, 64)
+			if err != nil {
+				session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**", err.Error(), "**"))
+				return
+			}
+
+			err = guildData.UpdateMinMessageLength(value)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**Messages now need at least ", guildData.MinMessageLength, " characters to count as activity**"))
+			return
+		}
+
+		value, err := parseToggle(command[2])
+		if err != nil {
+			session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**", err.Error(), "**"))
+			return
+		}
+
+		err = guildData.SetMessageRule(rule, value)
+		if err != nil {
+			session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**", err.Error(), "**"))
+			return
+		}
+		session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**Message rule ", rule, " set to: ", value, "**"))
+		break
+
+	case "pause":
+		until := time.Time{}
+		if len(command) > 1 {
+			duration, err := parseDuration(command[1])
+			if err != nil {
+				session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**", err.Error(), "**"))
+				return
+			}
+			until = time.Now().UTC().Add(duration)
+		}
+
+		err = guildData.SetPause(true, until)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		if until.IsZero() {
+			session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**Automated warnings and kicks are paused until you run `", cmdTag, " resume`, activity is still being recorded**"))
+			return
+		}
+		session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**Automated warnings and kicks are paused until ", until.Format("2006-01-02 15:04"), " UTC, activity is still being recorded**"))
+		break
+
+	case "resume":
+		err = guildData.SetPause(false, time.Time{})
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		session.ChannelMessageSend(data.ChannelID, "**Automated warnings and kicks are running again**")
+		break
+
+	case "schedule":
+		if len(command) == 1 {
+			session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**Kicks happen ", scheduleMeaning(guildData), "**"))
+			return
+		}
+
+		if len(command) > 3 {
+			session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**Usage: ", cmdTag, " schedule (HH:MM/any) [timezone]**"))
+			return
+		}
+
+		runTime, err := parseRunTime(command[1])
+		if err != nil {
+			session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**", err.Error(), "**"))
+			return
+		}
+
+		// Keep the current timezone unless a new one is given
+		timezone := guildData.Timezone
+		if len(command) == 3 {
+			timezone = command[2]
+		}
+
+		err = guildData.SetSchedule(runTime, timezone)
+		if err != nil {
+			session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**", err.Error(), "**"))
+			return
+		}
+		session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**Kicks now happen ", scheduleMeaning(guildData), "**"))
+		break
+
+	case "archivedthreads":
+		if len(command) == 1 {
+			session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**Activity in archived threads counts: ", !guildData.IgnoreArchivedThreads, "**"))
+			return
+		}
+
+		value, err := parseToggle(command[1])
+		if err != nil {
+			session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**", err.Error(), "**"))
+			return
+		}
+
+		err = guildData.SetIgnoreArchivedThreads(!value)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**Activity in archived threads counts: ", !guildData.IgnoreArchivedThreads, "**"))
+		break
+
+	case "undo":
+		if guildData.LastBatch == "" {
+			session.ChannelMessageSend(data.ChannelID, "**There are no automated kicks to undo**")
+			return
+		}
+
+		session.ChannelMessageSend(data.ChannelID, "**Undoing the last batch of kicks...**")
+		sent, total, err := handleUndo(session, guild, guildData)
+		if err != nil {
+			session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**", err.Error(), "**"))
+			return
+		}
+
+		session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**Done, sent invites to ", sent, " of ", total, " users, their roles will be restored when they rejoin**"))
+		break
+
+	default:
+
+		member := mentionToMember(session, guild.ID, command[0])
+		if member == nil {
+
+			// Alert the user that the command was not found
+			session.ChannelMessageSend(data.ChannelID, fmt.Sprint(command[0], ": ", notFoundText))
 			session.ChannelMessageDelete(data.ChannelID, data.ID)
 
 			// User was not found
 			return
 		} else {
-			yeet(session, data.GuildID, member.User.ID, "**Thou hath been yeeteth by the server owner**", "Yeeted by owner")
+			yeet(session, data.GuildID, member.User.ID, "**Thou hath been yeeteth by the server owner**", "Yeeted by owner", "")
 		}
 
 		break
 	}
 }
 
-func handleUpdateData(session *discord.Session, data *discord.MessageCreate) {
+func handleUpdateData(session *discord.Session, data *discord.MessageCreate, guild *discord.Guild) {
+	userId := data.Author.ID
+	source := SourceMessage
 
-	// Parse time stamp
-	stamp, err := data.Timestamp.Parse()
-	if err != nil {
-		log.Println(err)
-		return
+	// Messages in threads are thread posts
+	channel, err := session.State.Channel(data.ChannelID)
+	if err == nil && channel.IsThread() {
+		source = SourceThread
 	}
 
-	// Try to get the user
-	user, err := GetUser(data.GuildID, data.Author.ID)
-	if err != nil {
+	// Slash commands of other bots show up as messages from that bot, credit whoever used the command
+	if data.Interaction != nil && data.Interaction.User != nil {
+		userId = data.Interaction.User.ID
+		source = SourceSlash
+	} else {
 
-		// User does not exist, create them
-		err := CreateUser(data.GuildID, data.Author.ID, stamp)
+		// Don't let people game the bot with a monthly "."
+		guildData, err := getCachedGuild(data.GuildID)
 		if err != nil {
-
-			// Something bad happened?
 			log.Println(err)
 			return
 		}
 
-		return
+		if !messageCounts(guildData, userId, data.Content, len(data.Attachments) > 0 || len(data.StickerItems) > 0) {
+			return
+		}
 	}
 
-	// Update the user's activity
-	user.UpdateActivity(stamp)
+	trackActivity(session, guild, data.ChannelID, userId, data.Timestamp.UTC(), source, 1)
 }
 
 func getMemberList(session *discord.Session, guild *discord.Guild) []*discord.Member {
@@ -586,29 +1569,228 @@ func handleForceAdd(session *discord.Session, guild *discord.Guild) int {
 
 		currentTime := time.Now().UTC()
 
-		// See if the user exists
-		_, err := GetUser(guild.ID, member.User.ID)
+		// Create the user if they don't exist yet
+		created, err := CreateUser(guild.ID, member.User.ID, currentTime, SourceForceAdd)
 		if err != nil {
 
-			// User does not exist, create them
-			err := CreateUser(guild.ID, member.User.ID, currentTime)
-			if err != nil {
-
-				// Something bad happened?
-				log.Println(err)
-				continue
-			}
+			// Something bad happened?
+			log.Println(err)
+			continue
+		}
 
+		if created {
 			amount++
 		}
 	}
 	return amount
 }
 
+func handleUndo(session *discord.Session, guild *discord.Guild, guildData *GuildData) (int, int, error) {
+	sent := 0
+
+	records, err := GetKickBatch(guild.ID, guildData.LastBatch)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	inviteChannel := getInviteChannel(session, guild)
+	if inviteChannel == "" {
+		return 0, len(records), errors.New("Could not find a channel to create invites for")
+	}
+
+	// Create the invites before anything is changed, so the undo can be tried again if it fails
+	invites := make(map[string]string)
+	for _, record := range records {
+
+		// Every user gets their own single use invite
+		invite, err := session.ChannelInviteCreate(inviteChannel, discord.Invite{MaxAge: 7 * 24 * 60 * 60, MaxUses: 1, Unique: true})
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		invites[record.UserId] = invite.Code
+	}
+
+	if len(records) > 0 && len(invites) == 0 {
+		return 0, len(records), errors.New("Could not create invites, check the bot's permissions and try again")
+	}
+
+	// Roles get restored once the users come back
+	err = MarkBatchForRestore(guild.ID, guildData.LastBatch)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// The batch can only be undone once
+	err = guildData.SetLastBatch("")
+	if err != nil {
+		log.Println(err)
+	}
+
+	for _, record := range records {
+		code, ok := invites[record.UserId]
+		if !ok {
+			continue
+		}
+
+		channel, err := session.UserChannelCreate(record.UserId)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		inviteRepl := strings.ReplaceAll(undoMessage, "%invite%", fmt.Sprint("https://discord.gg/", code))
+		serverRepl := strings.ReplaceAll(inviteRepl, "%server%", guild.Name)
+
+		_, err = session.ChannelMessageSend(channel.ID, serverRepl)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		sent++
+	}
+	return sent, len(records), nil
+}
+
+func getInviteChannel(session *discord.Session, guild *discord.Guild) string {
+
+	// Prefer the channel discord sends its welcome messages to
+	if guild.SystemChannelID != "" {
+		return guild.SystemChannelID
+	}
+
+	channels, err := session.GuildChannels(guild.ID)
+	if err != nil {
+		log.Println(err)
+		return ""
+	}
+
+	// Otherwise just take the first text channel
+	for _, channel := range channels {
+		if channel.Type == discord.ChannelTypeGuildText {
+			return channel.ID
+		}
+	}
+	return ""
+}
+
+// Sends the help dialog, split over multiple messages if it doesn't fit in one
+func sendHelp(session *discord.Session, channelId string) {
+	message := helpHeader + "```\n"
+
+	for _, command := range helpCommands {
+
+		// Leave room for closing the code block
+		if len(message)+len(command)+len("\n```") >= maxMessageLength {
+			session.ChannelMessageSend(channelId, message+"```")
+			message = "```\n"
+		}
+		message += command + "\n"
+	}
+	message += "```\n"
+
+	if len(message)+len(helpFooter) >= maxMessageLength {
+		session.ChannelMessageSend(channelId, message)
+		message = ""
+	}
+	session.ChannelMessageSend(channelId, message+helpFooter)
+}
+
+func channelListMeaning(guildData *GuildData) string {
+	if guildData.ChannelAllowList && len(guildData.IgnoredChannels) == 0 && len(guildData.IgnoredCategories) == 0 {
+		return "are the only ones that count as activity, since there are none every channel counts"
+	}
+	if guildData.ChannelAllowList {
+		return "are the only ones that count as activity"
+	}
+	return "don't count as activity"
+}
+
+// Parses a duration like 3d, 12h or 30m
+func parseDuration(value string) (time.Duration, error) {
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.ParseInt(strings.TrimSuffix(value, "d"), 0, 64)
+		if err == nil && days > 0 {
+			return time.Duration(days) * 24 * time.Hour, nil
+		}
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return 0, errors.New(fmt.Sprint("Invalid duration ", value, ", use something like 3d, 12h or 30m"))
+	}
+	return duration, nil
+}
+
+// Parses a time of day like 18:00 into minutes after midnight, any means no particular time
+func parseRunTime(value string) (int64, error) {
+	if strings.ToLower(value) == "any" {
+		return -1, nil
+	}
+
+	stamp, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, errors.New(fmt.Sprint("Invalid time ", value, ", use HH:MM"))
+	}
+	return int64(stamp.Hour()*60 + stamp.Minute()), nil
+}
+
+// Describes when a guild's kicks happen
+func scheduleMeaning(guildData *GuildData) string {
+	timezone := guildData.Location().String()
+
+	if guildData.RunTime < 0 {
+		return fmt.Sprint("once a day at any time, days are counted in ", timezone)
+	}
+	return fmt.Sprintf("once a day at %02d:%02d %s", guildData.RunTime/60, guildData.RunTime%60, timezone)
+}
+
+func parseToggle(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "on", "true", "yes", "enable":
+		return true, nil
+	case "off", "false", "no", "disable":
+		return false, nil
+	}
+	return false, errors.New(fmt.Sprint("Expected on or off, got ", value))
+}
+
+// Gets a guild from the state cache, only asking discord if it isn't cached
+func GetDiscordGuild(session *discord.Session, guildId string) (*discord.Guild, error) {
+	guild, err := session.State.Guild(guildId)
+	if err == nil {
+		return guild, nil
+	}
+	return session.Guild(guildId)
+}
+
+func mentionToChannel(session *discordgo.Session, guildId, mention string) *discordgo.Channel {
+
+	// Allow plain channel ids as well
+	mention = strings.TrimSuffix(strings.TrimPrefix(mention, "<#"), ">")
+
+	channel, err := session.State.Channel(mention)
+	if err != nil {
+		channel, err = session.Channel(mention)
+		if err != nil {
+			log.Println(err)
+			return nil
+		}
+	}
+
+	// Don't allow channels from other servers
+	if channel.GuildID != guildId {
+		return nil
+	}
+
+	return channel
+}
+
 func mentionToMember(session *discordgo.Session, guildId, mention string) *discordgo.Member {
 
 	// It wasn't a mention after all
-	if mention[:2] != "<@" {
+	if len(mention) < 4 || mention[:2] != "<@" {
 		return nil
 	}
 
@@ -0,0 +1,195 @@
+package bot
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	discord "github.com/bwmarrin/discordgo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Every source of activity a guild can choose from
+var ActivitySources = []string{
+	SourceMessage,
+	SourceVoice,
+	SourceVoiceTime,
+	SourceReaction,
+	SourceThread,
+	SourceSlash,
+	SourceStage,
+	SourcePresence,
+}
+
+// The longest window a score can be calculated over
+const maxScoreWindow = 365
+
+// What counts as activity for guilds that haven't picked their own sources
+// Thread posts and stages counted as messages and voice before sources existed, so they still do
+var defaultActivityWeights = map[string]float64{
+	SourceMessage: 1,
+	SourceVoice:   1,
+	SourceThread:  1,
+	SourceStage:   1,
+}
+
+func isActivitySource(source string) bool {
+	for _, activitySource := range ActivitySources {
+		if strings.ToLower(source) == activitySource {
+			return true
+		}
+	}
+	return false
+}
+
+// Updates the activity of a user if the guild counts the source and the channel as activity
+// The channel can be empty for activity that doesn't happen in a channel
+// The amount is how many times the source's weight gets added to the user's score
+func trackActivity(session *discord.Session, guild *discord.Guild, channelId, userId string, stamp time.Time, source string, amount float64) {
+
+	// Dont count the bot's activity
+	if userId == SelfId {
+		return
+	}
+
+	// Don't count the owner's activity, they are automatically immune anyways
+	if userId == guild.OwnerID {
+		return
+	}
+
+	guildData, err := getCachedGuild(guild.ID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	weight := guildData.ActivityWeight(source)
+	if weight <= 0 {
+		return
+	}
+
+	// Activity in excluded channels doesn't count, threads go by the channel they're in
+	if channelId != "" {
+		parentId, categoryId, archived := resolveChannel(session, channelId)
+		if archived && guildData.IgnoreArchivedThreads {
+			return
+		}
+
+		if !guildData.CountsChannel(parentId, categoryId) {
+			return
+		}
+	}
+
+	// Both get written to the database with the next flush
+	bufferActivity(guild.ID, userId, stamp, source)
+
+	// Add to the user's activity score for the day
+	bufferActivityScore(guild.ID, userId, dayOf(stamp), weight*amount)
+}
+
+type DailyActivity struct {
+	GuildId      string  `bson:"guildId"`
+	UserId       string  `bson:"userId"`
+	Day          int64   `bson:"day"`
+	Score        float64 `bson:"score"`
+	VoiceMinutes float64 `bson:"voiceMinutes"`
+}
+
+// Gets the number of days since the unix epoch, in UTC
+func dayOf(stamp time.Time) int64 {
+	unixDay := int64(24 * time.Hour.Seconds())
+	return stamp.Unix() / unixDay
+}
+
+func AddActivityScore(guildId, userId string, day int64, score float64) error {
+	filter := bson.D{{"guildId", guildId}, {"userId", userId}, {"day", day}}
+	update := bson.D{{"$inc", bson.D{{"score", score}}}}
+
+	_, err := MongoClient.ActivityCollection().UpdateOne(context.Background(), filter, update, options.Update().SetUpsert(true))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Gets the total activity score of every user in a guild from the given day onwards
+func GetActivityScores(guildId string, fromDay int64) (map[string]float64, error) {
+	scores := make(map[string]float64)
+
+	pipeline := mongo.Pipeline{
+		{{"$match", bson.D{{"guildId", guildId}, {"day", bson.D{{"$gte", fromDay}}}}}},
+		{{"$group", bson.D{{"_id", "$userId"}, {"score", bson.D{{"$sum", "$score"}}}}}},
+	}
+
+	cur, err := MongoClient.ActivityCollection().Aggregate(context.Background(), pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(context.Background())
+
+	for cur.Next(context.Background()) {
+		var result struct {
+			UserId string  `bson:"_id"`
+			Score  float64 `bson:"score"`
+		}
+
+		err := cur.Decode(&result)
+		if err != nil {
+			return nil, err
+		}
+		scores[result.UserId] = result.Score
+	}
+	return scores, nil
+}
+
+// Deletes the days that are too old to fall within any guild's score window
+func PruneActivity() error {
+	oldestDay := dayOf(time.Now().UTC()) - maxScoreWindow
+
+	_, err := MongoClient.ActivityCollection().DeleteMany(context.Background(), bson.D{{"day", bson.D{{"$lt", oldestDay}}}})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func DeleteActivityForGuild(guildId string) error {
+	_, err := MongoClient.ActivityCollection().DeleteMany(context.Background(), bson.D{{"guildId", guildId}})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func DeleteActivityForUser(guildId, userId string) error {
+	_, err := MongoClient.ActivityCollection().DeleteMany(context.Background(), bson.D{{"guildId", guildId}, {"userId", userId}})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Gets the channel activity should be attributed to and the category that channel is in, if any
+// Threads and forum posts are attributed to the channel they were created in
+// Also returns whether the channel is a thread that has been archived
+func resolveChannel(session *discord.Session, channelId string) (string, string, bool) {
+	channel, err := session.State.Channel(channelId)
+	if err != nil {
+		return channelId, "", false
+	}
+
+	if !channel.IsThread() {
+		return channel.ID, channel.ParentID, false
+	}
+
+	archived := channel.ThreadMetadata != nil && channel.ThreadMetadata.Archived
+
+	parent, err := session.State.Channel(channel.ParentID)
+	if err != nil {
+		return channel.ParentID, "", archived
+	}
+	return parent.ID, parent.ParentID, archived
+}
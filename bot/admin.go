@@ -0,0 +1,305 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	discord "github.com/bwmarrin/discordgo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Gets every guild in the database, sorted by id
+func ListGuilds() ([]GuildData, error) {
+	var guilds []GuildData
+
+	opts := options.Find().SetSort(bson.D{{"guildId", 1}})
+	cur, err := MongoClient.ServersCollection().Find(context.Background(), bson.D{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(context.Background())
+
+	err = cur.All(context.Background(), &guilds)
+	if err != nil {
+		return nil, err
+	}
+	return guilds, nil
+}
+
+// Gets how many users of a guild are being tracked
+func CountUsers(guildId string) (int64, error) {
+	return MongoClient.UsersCollection().CountDocuments(context.Background(), bson.D{{"guildId", guildId}})
+}
+
+// Deletes everything stored about a guild, including its kick history
+// The bot has to be stopped, otherwise its buffered activity and next events bring the guild and its users back
+func PurgeGuild(guildId string) error {
+	discardGuildActivity(guildId)
+
+	err := DeleteUsersForGuild(guildId)
+	if err != nil {
+		return err
+	}
+
+	err = DeletePendingKicksForGuild(guildId)
+	if err != nil {
+		return err
+	}
+
+	err = DeleteActivityForGuild(guildId)
+	if err != nil {
+		return err
+	}
+
+	err = DeleteKicksForGuild(guildId)
+	if err != nil {
+		return err
+	}
+	return DeleteGuild(guildId)
+}
+
+// Works out who would be warned and kicked in a guild without sending or kicking anything
+// Without discord the owner isn't known, so they're only skipped if the owner id is given
+// The member count isn't known either, the percentage limit is checked against the tracked users instead
+// Returns the report and the ids of the users that would be warned and kicked, or queued for review
+func EvaluateGuild(guildId, ownerId string) (RunReport, []string, []string, error) {
+	var warned []string
+	var kicked []string
+
+	guildData, err := GetGuild(guildId)
+	if err != nil {
+		return RunReport{}, nil, nil, err
+	}
+
+	members, err := CountUsers(guildId)
+	if err != nil {
+		return RunReport{}, nil, nil, err
+	}
+
+	guild := &discord.Guild{ID: guildId, OwnerID: ownerId, MemberCount: int(members)}
+	report := RunReport{Dry: true}
+
+	warnings, queued, kicks, err := planRun(guild, guildData, false, &report)
+	if err != nil {
+		return report, nil, nil, err
+	}
+
+	for _, warning := range warnings {
+		warned = append(warned, warning.user.UserId)
+	}
+	for _, kick := range append(queued, kicks...) {
+		kicked = append(kicked, kick.user.UserId)
+	}
+	return report, warned, kicked, nil
+}
+
+// Gets every setting of a guild under the name it's stored as
+func GuildSettings(guildData *GuildData) (bson.D, error) {
+	return documentFields(*guildData)
+}
+
+// Sets a setting of the guild by the name it's stored under, parsing the value to the setting's type
+// Settings go through the same checks as the commands, the ones the bot keeps track of itself can't be set
+// Lists are comma separated and activity weights are written like message=1,voice=0.5
+func (self *GuildData) SetField(name, value string) error {
+	var parsed reflect.Value
+
+	settings := reflect.ValueOf(self).Elem()
+	for i := 0; i < settings.NumField(); i++ {
+		tag := strings.Split(settings.Type().Field(i).Tag.Get("bson"), ",")[0]
+		if tag != name {
+			continue
+		}
+
+		field, err := parseField(settings.Field(i).Type(), value)
+		if err != nil {
+			return errors.New(fmt.Sprint("Invalid value for ", name, ": ", err.Error()))
+		}
+		parsed = field
+	}
+
+	if !parsed.IsValid() {
+		return errors.New(fmt.Sprint("Unknown setting ", name))
+	}
+
+	switch name {
+	case "kickmsg":
+		return self.SetKickMsg(parsed.String())
+	case "warnmsg":
+		return self.SetWarnMsg(parsed.String())
+	case "dayInactivity":
+		return self.UpdateMaxInactivity(parsed.Int())
+	case "warnOffset":
+		return self.UpdateWarnOffset(parsed.Int())
+	case "restoreRoles":
+		return self.SetRestoreRoles(parsed.Bool())
+	case "restoreWindow":
+		return self.UpdateRestoreWindow(parsed.Int())
+	case "maxKicks":
+		return self.UpdateKickLimit(parsed.Int())
+	case "maxKickPercent":
+		return self.UpdateKickPercent(parsed.Int())
+	case "logChannel":
+		return self.SetLogChannel(parsed.String())
+	case "reviewKicks":
+		return self.SetReviewKicks(parsed.Bool())
+	case "scorePolicy":
+		return self.SetScorePolicy(parsed.Bool())
+	case "scoreThreshold":
+		return self.UpdateScorePolicy(parsed.Float(), self.ScoreWindow)
+	case "scoreWindow":
+		return self.UpdateScorePolicy(self.ScoreThreshold, parsed.Int())
+	case "minVoiceMinutes":
+		return self.UpdateMinVoiceMinutes(parsed.Int())
+	case "channelAllowList":
+		return self.SetChannelAllowList(parsed.Bool())
+	case "minMessageLength":
+		return self.UpdateMinMessageLength(parsed.Int())
+	case "ignoreEmojiOnly":
+		return self.SetMessageRule("emoji", parsed.Bool())
+	case "ignoreRepeatedWord":
+		return self.SetMessageRule("repeat", parsed.Bool())
+	case "ignoreDuplicates":
+		return self.SetMessageRule("duplicate", parsed.Bool())
+	case "ignoredChannels", "ignoredCategories":
+		return self.setList(name, parsed.Interface().([]string))
+	case "ignoreArchivedThreads":
+		return self.SetIgnoreArchivedThreads(parsed.Bool())
+	case "timezone":
+		return self.SetSchedule(self.RunTime, parsed.String())
+	case "runTime":
+		return self.SetSchedule(parsed.Int(), self.Timezone)
+	case "paused":
+		return self.SetPause(parsed.Bool(), self.PausedUntil)
+	case "pausedUntil":
+		return self.SetPause(self.Paused, parsed.Interface().(time.Time))
+	case "activityWeights":
+		return self.setActivityWeights(parsed.Interface().(map[string]float64))
+	}
+	return errors.New(fmt.Sprint(name, " is kept track of by the bot and can't be set"))
+}
+
+// Replaces the ignored channels or categories
+func (self *GuildData) setList(name string, list []string) error {
+	filter := bson.D{{"guildId", self.GuildId}}
+
+	if name == "ignoredCategories" {
+		self.IgnoredCategories = list
+	} else {
+		self.IgnoredChannels = list
+	}
+
+	// Update database
+	_, err := MongoClient.ServersCollection().ReplaceOne(context.Background(), filter, *self)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Sets the weight of every given source, no weights at all goes back to the defaults
+func (self *GuildData) setActivityWeights(weights map[string]float64) error {
+	filter := bson.D{{"guildId", self.GuildId}}
+
+	// Check everything first so a bad weight doesn't leave the others half set
+	for source, weight := range weights {
+		if !isActivitySource(source) {
+			return errors.New(fmt.Sprint("Unknown activity source ", source))
+		}
+		if weight < 0 {
+			return errors.New("Weight can't be negative")
+		}
+	}
+
+	if weights == nil {
+		self.ActivityWeights = nil
+
+		// Update database
+		_, err := MongoClient.ServersCollection().ReplaceOne(context.Background(), filter, *self)
+		if err != nil {
+			return err
+		}
+		return nil
+	}
+
+	for source, weight := range weights {
+		err := self.SetActivityWeight(strings.ToLower(source), weight)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseField(fieldType reflect.Type, value string) (reflect.Value, error) {
+	switch fieldType {
+	case reflect.TypeOf(time.Time{}):
+		stamp, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return reflect.ValueOf(stamp.UTC()), nil
+
+	case reflect.TypeOf([]string{}):
+		var list []string
+		for _, item := range strings.Split(value, ",") {
+			if strings.TrimSpace(item) != "" {
+				list = append(list, strings.TrimSpace(item))
+			}
+		}
+		return reflect.ValueOf(list), nil
+
+	case reflect.TypeOf(map[string]float64{}):
+		if value == "" {
+			return reflect.ValueOf(map[string]float64(nil)), nil
+		}
+
+		weights := make(map[string]float64)
+		for _, item := range strings.Split(value, ",") {
+			parts := strings.SplitN(item, "=", 2)
+			if len(parts) != 2 {
+				return reflect.Value{}, errors.New(fmt.Sprint("expected source=weight, got ", item))
+			}
+
+			weight, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			weights[strings.TrimSpace(parts[0])] = weight
+		}
+		return reflect.ValueOf(weights), nil
+	}
+
+	switch fieldType.Kind() {
+	case reflect.String:
+		return reflect.ValueOf(value), nil
+
+	case reflect.Bool:
+		parsed, err := parseToggle(value)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return reflect.ValueOf(parsed), nil
+
+	case reflect.Int64:
+		parsed, err := strconv.ParseInt(value, 0, 64)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return reflect.ValueOf(parsed), nil
+
+	case reflect.Float64:
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return reflect.ValueOf(parsed), nil
+	}
+	return reflect.Value{}, errors.New(fmt.Sprint("settings of type ", fieldType, " can't be edited"))
+}
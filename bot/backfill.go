@@ -0,0 +1,242 @@
+package bot
+
+import (
+	"log"
+	"time"
+
+	discord "github.com/bwmarrin/discordgo"
+)
+
+// Discord won't give out more messages than this per request
+const messagePageSize = 100
+
+// Discord won't give out more archived threads than this per request
+const threadPageSize = 100
+
+// Finds every channel and thread whose messages count as activity for the guild
+func historyChannels(session *discord.Session, guildData *GuildData, since time.Time) ([]*discord.Channel, error) {
+	var result []*discord.Channel
+
+	channels, err := session.GuildChannels(guildData.GuildId)
+	if err != nil {
+		return nil, err
+	}
+
+	// Threads get attributed to the channel they're in
+	parents := make(map[string]*discord.Channel)
+	for _, channel := range channels {
+		parents[channel.ID] = channel
+	}
+
+	countsChannel := func(channel *discord.Channel) bool {
+		parent, ok := parents[channel.ParentID]
+		if !channel.IsThread() || !ok {
+			return guildData.CountsChannel(channel.ID, channel.ParentID)
+		}
+		return guildData.CountsChannel(parent.ID, parent.ParentID)
+	}
+
+	for _, channel := range channels {
+		if channel.Type != discord.ChannelTypeGuildText && channel.Type != discord.ChannelTypeGuildNews && channel.Type != discord.ChannelTypeGuildForum {
+			continue
+		}
+
+		if !countsChannel(channel) {
+			continue
+		}
+
+		// Forums only have messages in their posts
+		if channel.Type != discord.ChannelTypeGuildForum {
+			result = append(result, channel)
+		}
+
+		if guildData.IgnoreArchivedThreads {
+			continue
+		}
+
+		// Get every thread that was archived within the time we're looking at
+		before := time.Now().UTC()
+		for {
+			threads, err := session.ThreadsArchived(channel.ID, &before, threadPageSize)
+			if err != nil || len(threads.Threads) == 0 {
+				break
+			}
+
+			for _, thread := range threads.Threads {
+				if thread.ThreadMetadata != nil && !thread.ThreadMetadata.ArchiveTimestamp.Before(since) {
+					result = append(result, thread)
+				}
+			}
+
+			// Threads come newest archive first, stop once they're too old
+			last := threads.Threads[len(threads.Threads)-1]
+			if !threads.HasMore || last.ThreadMetadata == nil || last.ThreadMetadata.ArchiveTimestamp.Before(since) {
+				break
+			}
+			before = last.ThreadMetadata.ArchiveTimestamp
+		}
+	}
+
+	// Threads that are still going
+	active, err := session.GuildThreadsActive(guildData.GuildId)
+	if err != nil {
+		log.Println(err)
+		return result, nil
+	}
+
+	for _, thread := range active.Threads {
+		if countsChannel(thread) {
+			result = append(result, thread)
+		}
+	}
+	return result, nil
+}
+
+// Walks the message history of every readable channel and thread back to the given time
+// Returns when each user last posted a message and how many messages were read
+func scanMessageHistory(session *discord.Session, guildData *GuildData, since time.Time) (map[string]time.Time, int, error) {
+	latest := make(map[string]time.Time)
+	scanned := 0
+
+	// Guilds that don't count messages have nothing to find
+	if guildData.ActivityWeight(SourceMessage) <= 0 {
+		return latest, scanned, nil
+	}
+
+	channels, err := historyChannels(session, guildData, since)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for _, channel := range channels {
+
+		// Skip channels the bot can't read the history of, threads inherit their permissions
+		permissionChannel := channel.ID
+		if channel.IsThread() {
+			permissionChannel = channel.ParentID
+		}
+
+		perms, err := session.State.UserChannelPermissions(SelfId, permissionChannel)
+		if err != nil || perms&discord.PermissionViewChannel == 0 || perms&discord.PermissionReadMessageHistory == 0 {
+			continue
+		}
+
+		scanned += scanChannelHistory(session, guildData, channel.ID, since, latest)
+	}
+	return latest, scanned, nil
+}
+
+// Walks the message history of a single channel back to the given time, keeping track of everyone's latest message that counts
+// Returns how many messages were read
+func scanChannelHistory(session *discord.Session, guildData *GuildData, channelId string, since time.Time, latest map[string]time.Time) int {
+	scanned := 0
+
+	// Value which tells discord which message precedes the ones we're getting next
+	beforeMessage := ""
+
+	for {
+		messages, err := session.ChannelMessages(channelId, messagePageSize, beforeMessage, "", "")
+		if err != nil {
+			log.Println(err)
+			break
+		}
+
+		reachedEnd := len(messages) < messagePageSize
+		for _, message := range messages {
+			stamp := message.Timestamp
+
+			// Messages come newest first, so everything after this is too old
+			if stamp.Before(since) {
+				reachedEnd = true
+				break
+			}
+			scanned++
+
+			// Webhooks and bots aren't members we track
+			if message.Author == nil || message.Author.Bot || message.WebhookID != "" {
+				continue
+			}
+
+			// The first message we see from someone is their most recent one, older ones don't need checking
+			if previous, ok := latest[message.Author.ID]; ok && !stamp.After(previous) {
+				continue
+			}
+
+			// Messages have to pass the same rules as they would've when they were sent
+			if !messageCounts(guildData, message.Author.ID, message.Content, len(message.Attachments) > 0 || len(message.StickerItems) > 0) {
+				continue
+			}
+			latest[message.Author.ID] = stamp.UTC()
+		}
+
+		if reachedEnd {
+			break
+		}
+
+		beforeMessage = messages[len(messages)-1].ID
+	}
+	return scanned
+}
+
+// Sets the activity of every tracked user to their most recent message since the given time
+// Returns the amount of users that got updated and the amount of messages that were read
+func BackfillGuild(session *discord.Session, guild *discord.Guild, since time.Time) (int, int, error) {
+	updated := 0
+
+	guildData, err := GetGuild(guild.ID)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	latest, scanned, err := scanMessageHistory(session, guildData, since)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Compare against the activity that's still waiting to be written as well
+	err = FlushActivity()
+	if err != nil {
+		log.Println(err)
+	}
+
+	for userId, stamp := range latest {
+
+		// The owner and the bot aren't tracked
+		if userId == guild.OwnerID || userId == SelfId {
+			continue
+		}
+
+		// Users that aren't tracked have most likely left already
+		user, err := GetUser(guild.ID, userId)
+		if err != nil {
+			continue
+		}
+
+		// Force added users got stamped with the time they were added, which isn't real activity
+		// Everyone else only ever gets their activity moved forward
+		if user.ActivitySource != SourceForceAdd && !stamp.After(user.LastActivity) {
+			continue
+		}
+
+		err = user.UpdateActivity(stamp, SourceBackfill)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		updated++
+	}
+	return updated, scanned, nil
+}
+
+// Backfills every guild the bot is in, used to pick up the activity missed while the bot was offline
+func BackfillAfterOutage(session *discord.Session, since time.Time) {
+	for _, guild := range session.State.Guilds {
+		updated, scanned, err := BackfillGuild(session, guild, since)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		log.Println("Backfilled", guild.ID, "-", updated, "users from", scanned, "messages...")
+	}
+}
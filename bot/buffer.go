@@ -0,0 +1,295 @@
+package bot
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// How often buffered activity gets written to the database
+const activityFlushInterval = 5 * time.Second
+
+// How long guild settings are reused for when tracking activity
+const guildCacheTime = 5 * time.Second
+
+// How long activity of users that left is ignored for, events can still arrive after a user left
+const departedUserMemory = 10 * time.Minute
+
+type userKey struct {
+	guildId string
+	userId  string
+}
+
+type scoreKey struct {
+	guildId string
+	userId  string
+	day     int64
+}
+
+type bufferedActivity struct {
+	stamp  time.Time
+	source string
+}
+
+type cachedGuild struct {
+	data    GuildData
+	fetched time.Time
+}
+
+var (
+	activityBuffer     = make(map[userKey]bufferedActivity)
+	scoreBuffer        = make(map[scoreKey]float64)
+	departedUsers      = make(map[userKey]time.Time)
+	activityBufferLock sync.Mutex
+
+	// Held while a flush is being written so that deletes can wait for it
+	activityFlushLock sync.Mutex
+	activityFlushOnce sync.Once
+
+	guildCache     = make(map[string]cachedGuild)
+	guildCacheLock sync.Mutex
+)
+
+// Remembers the activity of a user until the next flush, only the most recent activity is kept
+func bufferActivity(guildId, userId string, stamp time.Time, source string) {
+	activityBufferLock.Lock()
+	defer activityBufferLock.Unlock()
+
+	key := userKey{guildId, userId}
+	if _, ok := departedUsers[key]; ok {
+		return
+	}
+	if previous, ok := activityBuffer[key]; ok && previous.stamp.After(stamp) {
+		return
+	}
+	activityBuffer[key] = bufferedActivity{stamp, source}
+}
+
+// Adds to the activity score of a user until the next flush
+func bufferActivityScore(guildId, userId string, day int64, score float64) {
+	activityBufferLock.Lock()
+	defer activityBufferLock.Unlock()
+
+	if _, ok := departedUsers[userKey{guildId, userId}]; ok {
+		return
+	}
+	scoreBuffer[scoreKey{guildId, userId, day}] += score
+}
+
+// Forgets the buffered activity of a user that left, so that a flush doesn't bring back a deleted user
+// Activity that still comes in for them is ignored until they rejoin
+func discardActivity(guildId, userId string) {
+	activityFlushLock.Lock()
+	defer activityFlushLock.Unlock()
+
+	activityBufferLock.Lock()
+	defer activityBufferLock.Unlock()
+
+	departedUsers[userKey{guildId, userId}] = time.Now()
+	delete(activityBuffer, userKey{guildId, userId})
+	for key := range scoreBuffer {
+		if key.guildId == guildId && key.userId == userId {
+			delete(scoreBuffer, key)
+		}
+	}
+}
+
+// Starts accepting activity of a user that rejoined
+func forgetDeparture(guildId, userId string) {
+	activityBufferLock.Lock()
+	defer activityBufferLock.Unlock()
+
+	delete(departedUsers, userKey{guildId, userId})
+}
+
+// Forgets the buffered activity of every user in a guild
+func discardGuildActivity(guildId string) {
+	activityFlushLock.Lock()
+	defer activityFlushLock.Unlock()
+
+	activityBufferLock.Lock()
+	defer activityBufferLock.Unlock()
+
+	for key := range activityBuffer {
+		if key.guildId == guildId {
+			delete(activityBuffer, key)
+		}
+	}
+	for key := range scoreBuffer {
+		if key.guildId == guildId {
+			delete(scoreBuffer, key)
+		}
+	}
+
+	guildCacheLock.Lock()
+	delete(guildCache, guildId)
+	guildCacheLock.Unlock()
+}
+
+// Starts writing the buffered activity to the database every few seconds
+func StartActivityFlush() {
+	activityFlushOnce.Do(func() {
+		go func() {
+			for {
+				time.Sleep(activityFlushInterval)
+
+				err := FlushActivity()
+				if err != nil {
+					log.Println(err)
+				}
+			}
+		}()
+	})
+}
+
+// Writes all buffered activity to the database in bulk
+// Activity that couldn't be written goes back into the buffer for the next flush
+func FlushActivity() error {
+	activityFlushLock.Lock()
+	defer activityFlushLock.Unlock()
+
+	// Swap the buffers out so that events don't have to wait for the database
+	activityBufferLock.Lock()
+	activity := activityBuffer
+	scores := scoreBuffer
+	activityBuffer = make(map[userKey]bufferedActivity)
+	scoreBuffer = make(map[scoreKey]float64)
+
+	// Users that left a while ago won't get any more events
+	for key, departed := range departedUsers {
+		if time.Since(departed) >= departedUserMemory {
+			delete(departedUsers, key)
+		}
+	}
+	activityBufferLock.Unlock()
+
+	opts := options.BulkWrite().SetOrdered(false)
+
+	if len(activity) > 0 {
+		var models []mongo.WriteModel
+		var keys []userKey
+		now := time.Now().UTC()
+
+		for key, update := range activity {
+			filter := bson.D{{"guildId", key.guildId}, {"userId", key.userId}}
+
+			// Users that don't exist yet get created the same way CreateUser would
+			models = append(models, mongo.NewUpdateOneModel().SetFilter(filter).SetUpsert(true).SetUpdate(bson.D{
+				{"$set", bson.D{{"lastActivity", update.stamp}, {"activitySource", update.source}, {"warnings", 0}}},
+				{"$setOnInsert", bson.D{{"trackedSince", now}, {"immune", false}, {"immuneUntil", time.Time{}}}},
+			}))
+			keys = append(keys, key)
+		}
+
+		_, err := MongoClient.UsersCollection().BulkWrite(context.Background(), models, opts)
+		if err != nil {
+			failed := failedWrites(err, len(keys))
+
+			activityBufferLock.Lock()
+			for i, key := range keys {
+				if failed[i] {
+					requeueActivity(key, activity[key])
+				}
+			}
+			for key, score := range scores {
+				requeueScore(key, score)
+			}
+			activityBufferLock.Unlock()
+			return err
+		}
+	}
+
+	if len(scores) > 0 {
+		var models []mongo.WriteModel
+		var keys []scoreKey
+
+		for key, score := range scores {
+			filter := bson.D{{"guildId", key.guildId}, {"userId", key.userId}, {"day", key.day}}
+			models = append(models, mongo.NewUpdateOneModel().SetFilter(filter).SetUpsert(true).SetUpdate(bson.D{{"$inc", bson.D{{"score", score}}}}))
+			keys = append(keys, key)
+		}
+
+		_, err := MongoClient.ActivityCollection().BulkWrite(context.Background(), models, opts)
+		if err != nil {
+			failed := failedWrites(err, len(keys))
+
+			activityBufferLock.Lock()
+			for i, key := range keys {
+				if failed[i] {
+					requeueScore(key, scores[key])
+				}
+			}
+			activityBufferLock.Unlock()
+			return err
+		}
+	}
+	return nil
+}
+
+// Works out which writes of a bulk write failed
+// Unless the database said which ones failed, all of them are assumed to have failed
+func failedWrites(err error, count int) []bool {
+	failed := make([]bool, count)
+
+	if bulkErr, ok := err.(mongo.BulkWriteException); ok && bulkErr.WriteConcernError == nil {
+		for _, writeErr := range bulkErr.WriteErrors {
+			if writeErr.Index < count {
+				failed[writeErr.Index] = true
+			}
+		}
+		return failed
+	}
+
+	for i := range failed {
+		failed[i] = true
+	}
+	return failed
+}
+
+// Puts activity that couldn't be written back into the buffer, newer activity that came in meanwhile wins
+// The buffer lock has to be held
+func requeueActivity(key userKey, update bufferedActivity) {
+	if _, ok := departedUsers[key]; ok {
+		return
+	}
+	if current, ok := activityBuffer[key]; ok && current.stamp.After(update.stamp) {
+		return
+	}
+	activityBuffer[key] = update
+}
+
+// Puts a score that couldn't be written back into the buffer, adding to what came in meanwhile
+// The buffer lock has to be held
+func requeueScore(key scoreKey, score float64) {
+	if _, ok := departedUsers[userKey{key.guildId, key.userId}]; ok {
+		return
+	}
+	scoreBuffer[key] += score
+}
+
+// Gets the settings of a guild, reusing them for a few seconds so busy guilds don't hit the database for every event
+// The result is a copy and must not be changed
+func getCachedGuild(guildId string) (*GuildData, error) {
+	guildCacheLock.Lock()
+	cached, ok := guildCache[guildId]
+	guildCacheLock.Unlock()
+
+	if ok && time.Since(cached.fetched) < guildCacheTime {
+		return &cached.data, nil
+	}
+
+	guildData, err := GetGuild(guildId)
+	if err != nil {
+		return nil, err
+	}
+
+	guildCacheLock.Lock()
+	guildCache[guildId] = cachedGuild{*guildData, time.Now()}
+	guildCacheLock.Unlock()
+	return guildData, nil
+}
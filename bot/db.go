@@ -14,6 +14,10 @@ import (
 const dbDbName string = "yeetbot"
 const dbServerCollectionName string = "servers"
 const dbUserCollectionName string = "users"
+const dbKickCollectionName string = "kicks"
+const dbReviewCollectionName string = "reviews"
+const dbMetaCollectionName string = "meta"
+const dbActivityCollectionName string = "activity"
 
 var MongoClient MClient
 
@@ -38,6 +42,22 @@ func (self MClient) UsersCollection() *mongo.Collection {
 	return self.client.Database(dbDbName).Collection(dbUserCollectionName)
 }
 
+func (self MClient) KicksCollection() *mongo.Collection {
+	return self.client.Database(dbDbName).Collection(dbKickCollectionName)
+}
+
+func (self MClient) ReviewsCollection() *mongo.Collection {
+	return self.client.Database(dbDbName).Collection(dbReviewCollectionName)
+}
+
+func (self MClient) MetaCollection() *mongo.Collection {
+	return self.client.Database(dbDbName).Collection(dbMetaCollectionName)
+}
+
+func (self MClient) ActivityCollection() *mongo.Collection {
+	return self.client.Database(dbDbName).Collection(dbActivityCollectionName)
+}
+
 func (self MClient) Disconnect() error {
 	return self.client.Disconnect(context.Background())
 }
@@ -67,6 +87,32 @@ func ConnectToDB(connectionString string) error {
 	// Apply this to our client
 	MongoClient = MClient{client}
 
+	// Duplicates from before the indexes existed keep them from being created, the bot works without them
+	err = EnsureIndexes()
+	if err != nil {
+		log.Println("Could not create indexes, run with -dedupe to fix duplicate data:", err)
+	}
+
 	log.Println("Connected to database!")
 	return nil
 }
+
+// Creates the indexes that keep the same user or guild from being stored twice
+func EnsureIndexes() error {
+	_, err := MongoClient.UsersCollection().Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{"guildId", 1}, {"userId", 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = MongoClient.ServersCollection().Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{"guildId", 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package bot
+
+import (
+	"context"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type storedUser struct {
+	Id       primitive.ObjectID `bson:"_id"`
+	UserData `bson:",inline"`
+}
+
+type storedGuild struct {
+	Id        primitive.ObjectID `bson:"_id"`
+	GuildData `bson:",inline"`
+}
+
+// Finds the ids of documents that share the same values for the given fields, grouped per value
+func findDuplicates(collection *mongo.Collection, fields ...string) ([][]primitive.ObjectID, error) {
+	var duplicates [][]primitive.ObjectID
+
+	group := bson.D{}
+	for _, field := range fields {
+		group = append(group, bson.E{field, "$" + field})
+	}
+
+	pipeline := mongo.Pipeline{
+		{{"$group", bson.D{{"_id", group}, {"ids", bson.D{{"$push", "$_id"}}}, {"count", bson.D{{"$sum", 1}}}}}},
+		{{"$match", bson.D{{"count", bson.D{{"$gt", 1}}}}}},
+	}
+
+	cur, err := collection.Aggregate(context.Background(), pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(context.Background())
+
+	for cur.Next(context.Background()) {
+		var result struct {
+			Ids []primitive.ObjectID `bson:"ids"`
+		}
+
+		err := cur.Decode(&result)
+		if err != nil {
+			return nil, err
+		}
+		duplicates = append(duplicates, result.Ids)
+	}
+	return duplicates, nil
+}
+
+// Merges users that are stored more than once into a single document
+// The most recent activity is kept and users stay immune if any of their copies were
+// Returns how many documents were removed
+func DedupeUsers() (int64, error) {
+	var removed int64
+
+	duplicates, err := findDuplicates(MongoClient.UsersCollection(), "guildId", "userId")
+	if err != nil {
+		return 0, err
+	}
+
+	for _, ids := range duplicates {
+		var users []storedUser
+
+		cur, err := MongoClient.UsersCollection().Find(context.Background(), bson.D{{"_id", bson.D{{"$in", ids}}}})
+		if err != nil {
+			return removed, err
+		}
+
+		err = cur.All(context.Background(), &users)
+		cur.Close(context.Background())
+		if err != nil {
+			return removed, err
+		}
+
+		if len(users) < 2 {
+			continue
+		}
+
+		merged := users[0]
+		for _, user := range users[1:] {
+			if user.LastActivity.After(merged.LastActivity) {
+				merged.LastActivity = user.LastActivity
+				merged.ActivitySource = user.ActivitySource
+			}
+
+			if !user.TrackedSince.IsZero() && (merged.TrackedSince.IsZero() || user.TrackedSince.Before(merged.TrackedSince)) {
+				merged.TrackedSince = user.TrackedSince
+			}
+
+			if user.ImmuneUntil.After(merged.ImmuneUntil) {
+				merged.ImmuneUntil = user.ImmuneUntil
+			}
+			merged.Immune = merged.Immune || user.Immune
+		}
+
+		// Keep the first copy and get rid of the rest
+		_, err = MongoClient.UsersCollection().ReplaceOne(context.Background(), bson.D{{"_id", merged.Id}}, merged.UserData)
+		if err != nil {
+			return removed, err
+		}
+
+		result, err := MongoClient.UsersCollection().DeleteMany(context.Background(), bson.D{{"guildId", merged.GuildId}, {"userId", merged.UserId}, {"_id", bson.D{{"$ne", merged.Id}}}})
+		if err != nil {
+			return removed, err
+		}
+		removed += result.DeletedCount
+	}
+	return removed, nil
+}
+
+// Removes guilds that are stored more than once, keeping the copy that was updated last
+// Returns how many documents were removed
+func DedupeGuilds() (int64, error) {
+	var removed int64
+
+	duplicates, err := findDuplicates(MongoClient.ServersCollection(), "guildId")
+	if err != nil {
+		return 0, err
+	}
+
+	for _, ids := range duplicates {
+		var guilds []storedGuild
+
+		cur, err := MongoClient.ServersCollection().Find(context.Background(), bson.D{{"_id", bson.D{{"$in", ids}}}})
+		if err != nil {
+			return removed, err
+		}
+
+		err = cur.All(context.Background(), &guilds)
+		cur.Close(context.Background())
+		if err != nil {
+			return removed, err
+		}
+
+		if len(guilds) < 2 {
+			continue
+		}
+
+		kept := guilds[0]
+		for _, guild := range guilds[1:] {
+			if guild.LastUpdated.After(kept.LastUpdated) {
+				kept = guild
+			}
+		}
+
+		result, err := MongoClient.ServersCollection().DeleteMany(context.Background(), bson.D{{"guildId", kept.GuildId}, {"_id", bson.D{{"$ne", kept.Id}}}})
+		if err != nil {
+			return removed, err
+		}
+		removed += result.DeletedCount
+	}
+	return removed, nil
+}
+
+// Gets rid of duplicate users and guilds, then creates the indexes that keep new ones from showing up
+func Dedupe() error {
+	users, err := DedupeUsers()
+	if err != nil {
+		return err
+	}
+	log.Println("Removed", users, "duplicate users...")
+
+	guilds, err := DedupeGuilds()
+	if err != nil {
+		return err
+	}
+	log.Println("Removed", guilds, "duplicate servers...")
+
+	return EnsureIndexes()
+}
@@ -0,0 +1,120 @@
+package bot
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// How often the bot tells the database that it's still alive
+const heartbeatInterval = 1 * time.Minute
+
+// Downtime shorter than this is just a restart and isn't worth correcting for
+const outageThreshold = 5 * time.Minute
+
+const heartbeatId = "heartbeat"
+
+var heartbeatOnce sync.Once
+
+type heartbeatData struct {
+	Id   string    `bson:"_id"`
+	Time time.Time `bson:"time"`
+}
+
+func Heartbeat() error {
+	filter := bson.D{{"_id", heartbeatId}}
+	update := bson.D{{"$set", bson.D{{"time", time.Now().UTC()}}}}
+
+	_, err := MongoClient.MetaCollection().UpdateOne(context.Background(), filter, update, options.Update().SetUpsert(true))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func GetLastHeartbeat() (time.Time, error) {
+	var heartbeat heartbeatData
+
+	err := MongoClient.MetaCollection().FindOne(context.Background(), bson.D{{"_id", heartbeatId}}).Decode(&heartbeat)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return heartbeat.Time, nil
+}
+
+// Checks whether the bot is running against the database, going by how recent its last heartbeat is
+// A bot that just stopped still looks like it's running for up to two heartbeats
+func BotRunning(now time.Time) (bool, error) {
+	lastSeen, err := GetLastHeartbeat()
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return now.Sub(lastSeen) < 2*heartbeatInterval, nil
+}
+
+// Starts writing heartbeats in the background, only the first call does anything
+func StartHeartbeat() {
+	heartbeatOnce.Do(func() {
+		go func() {
+			for {
+				err := Heartbeat()
+				if err != nil {
+					log.Println(err)
+				}
+				time.Sleep(heartbeatInterval)
+			}
+		}()
+	})
+}
+
+// Checks how long the bot was offline for and moves everyone's activity forward by that much
+// Returns the downtime, or 0 if there was no outage worth correcting
+func HandleOutage() (time.Duration, error) {
+	lastSeen, err := GetLastHeartbeat()
+	if err == mongo.ErrNoDocuments {
+
+		// First time starting, nothing was missed
+		return 0, Heartbeat()
+	} else if err != nil {
+		return 0, err
+	}
+
+	downtime := time.Now().UTC().Sub(lastSeen)
+	if downtime < outageThreshold {
+		return 0, nil
+	}
+
+	log.Println("Bot was offline for", downtime, "...")
+
+	// Write a heartbeat before correcting, a crash halfway through would otherwise shift everyone again on the next start
+	err = Heartbeat()
+	if err != nil {
+		return 0, err
+	}
+	return downtime, ShiftActivity(lastSeen, downtime)
+}
+
+// Moves the activity of every user whose activity is from before the given time forward
+// Only the time moves, where the activity came from and the warnings the users got are kept
+func ShiftActivity(before time.Time, offset time.Duration) error {
+	filter := bson.D{{"lastActivity", bson.D{{"$lt", before}}}}
+	update := mongo.Pipeline{
+		{{"$set", bson.D{{"lastActivity", bson.D{{"$add", bson.A{"$lastActivity", offset.Milliseconds()}}}}}}},
+	}
+
+	result, err := MongoClient.UsersCollection().UpdateMany(context.Background(), filter, update)
+	if err != nil {
+		return err
+	}
+
+	log.Println("Moved activity forward for", result.ModifiedCount, "users...")
+	return nil
+}
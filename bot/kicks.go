@@ -0,0 +1,125 @@
+package bot
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type KickRecord struct {
+	GuildId   string    `bson:"guildId"`
+	UserId    string    `bson:"userId"`
+	BatchId   string    `bson:"batchId"`
+	Roles     []string  `bson:"roles"`
+	Nick      string    `bson:"nick"`
+	KickedAt  time.Time `bson:"kickedAt"`
+	Automated bool      `bson:"automated"`
+	Restore   bool      `bson:"restore"`
+	Restored  bool      `bson:"restored"`
+}
+
+// Creates a new id for a batch of automated kicks
+func newBatchId() string {
+	return primitive.NewObjectID().Hex()
+}
+
+func CreateKickRecord(record KickRecord) error {
+	_, err := MongoClient.KicksCollection().InsertOne(context.Background(), record)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func GetKickBatch(guildId, batchId string) ([]KickRecord, error) {
+	var records []KickRecord
+
+	cur, err := MongoClient.KicksCollection().Find(context.Background(), bson.D{{"guildId", guildId}, {"batchId", batchId}})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(context.Background())
+
+	err = cur.All(context.Background(), &records)
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// Marks every member of a batch as someone whose roles should be given back when they rejoin
+func MarkBatchForRestore(guildId, batchId string) error {
+	filter := bson.D{{"guildId", guildId}, {"batchId", batchId}}
+	_, err := MongoClient.KicksCollection().UpdateMany(context.Background(), filter, bson.D{{"$set", bson.D{{"restore", true}}}})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Gets the kick record waiting for a user to rejoin, if there is one
+func GetPendingRestore(guildId, userId string) (*KickRecord, error) {
+	var record *KickRecord = new(KickRecord)
+
+	filter := bson.D{{"guildId", guildId}, {"userId", userId}, {"restore", true}, {"restored", false}}
+	err := MongoClient.KicksCollection().FindOne(context.Background(), filter).Decode(record)
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// Gets the most recent kick of a user since the given time that hasn't been restored yet
+func GetLatestKick(guildId, userId string, since time.Time) (*KickRecord, error) {
+	var record *KickRecord = new(KickRecord)
+
+	filter := bson.D{{"guildId", guildId}, {"userId", userId}, {"restored", false}, {"kickedAt", bson.D{{"$gte", since}}}}
+	opts := options.FindOne().SetSort(bson.D{{"kickedAt", -1}})
+	err := MongoClient.KicksCollection().FindOne(context.Background(), filter, opts).Decode(record)
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// Gets the most recent kicks of a user, newest first
+func GetKickHistory(guildId, userId string, limit int64) ([]KickRecord, error) {
+	var records []KickRecord
+
+	opts := options.Find().SetSort(bson.D{{"kickedAt", -1}}).SetLimit(limit)
+	cur, err := MongoClient.KicksCollection().Find(context.Background(), bson.D{{"guildId", guildId}, {"userId", userId}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(context.Background())
+
+	err = cur.All(context.Background(), &records)
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+func DeleteKicksForGuild(guildId string) error {
+	_, err := MongoClient.KicksCollection().DeleteMany(context.Background(), bson.D{{"guildId", guildId}})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (self *KickRecord) MarkRestored() error {
+	filter := bson.D{{"guildId", self.GuildId}, {"userId", self.UserId}, {"kickedAt", self.KickedAt}}
+
+	self.Restored = true
+
+	// Update database
+	_, err := MongoClient.KicksCollection().UpdateOne(context.Background(), filter, bson.D{{"$set", bson.D{{"restored", true}}}})
+	if err != nil {
+		return err
+	}
+	return nil
+}
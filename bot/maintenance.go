@@ -0,0 +1,103 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	discord "github.com/bwmarrin/discordgo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const maintenanceId = "maintenance"
+
+// Users that can run bot wide commands from any server, set from the config
+var BotAdmins []string
+
+// Whether automated warnings and kicks are stopped for every guild, 1 means they are
+var maintenanceMode int32
+
+type maintenanceData struct {
+	Id        string    `bson:"_id"`
+	Enabled   bool      `bson:"enabled"`
+	UpdatedAt time.Time `bson:"updatedAt"`
+}
+
+func InMaintenance() bool {
+	return atomic.LoadInt32(&maintenanceMode) == 1
+}
+
+// Loads the maintenance switch from the database, forcing it on if the config says so
+func LoadMaintenance(force bool) error {
+	var stored maintenanceData
+
+	err := MongoClient.MetaCollection().FindOne(context.Background(), bson.D{{"_id", maintenanceId}}).Decode(&stored)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+
+	if stored.Enabled || force {
+		atomic.StoreInt32(&maintenanceMode, 1)
+		log.Println("Maintenance mode is on, no automated warnings or kicks will happen...")
+	}
+	return nil
+}
+
+// Turns maintenance mode on or off and remembers it across restarts
+func SetMaintenance(enabled bool) error {
+	filter := bson.D{{"_id", maintenanceId}}
+	update := bson.D{{"$set", bson.D{{"enabled", enabled}, {"updatedAt", time.Now().UTC()}}}}
+
+	var value int32
+	if enabled {
+		value = 1
+	}
+	atomic.StoreInt32(&maintenanceMode, value)
+
+	_, err := MongoClient.MetaCollection().UpdateOne(context.Background(), filter, update, options.Update().SetUpsert(true))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Gets why kicks can't happen in the guild right now, or an empty string if they can
+func kicksStopped(guildData *GuildData, now time.Time) string {
+	if InMaintenance() {
+		return "**The bot is in maintenance mode, kicks are stopped until it's over**"
+	}
+	if guildData.IsPaused(now) {
+		return fmt.Sprint("**This server is paused, use `", cmdTag, " resume` first**")
+	}
+	return ""
+}
+
+func isBotAdmin(userId string) bool {
+	return containsString(BotAdmins, userId)
+}
+
+func handleMaintenance(session *discord.Session, data *discord.MessageCreate, args []string) {
+	if len(args) == 0 {
+		session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**Maintenance mode is set to: ", InMaintenance(), "**"))
+		return
+	}
+
+	value, err := parseToggle(args[0])
+	if err != nil {
+		session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**", err.Error(), "**"))
+		return
+	}
+
+	err = SetMaintenance(value)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	log.Println("Maintenance mode set to", value, "by", data.Author.ID)
+	session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**Maintenance mode set to: ", InMaintenance(), "**"))
+}
@@ -0,0 +1,169 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const schemaId = "schema"
+
+type schemaData struct {
+	Id        string    `bson:"_id"`
+	Version   int       `bson:"version"`
+	UpdatedAt time.Time `bson:"updatedAt"`
+}
+
+type migration struct {
+	description string
+	run         func() error
+}
+
+// Every change to how documents are stored, in the order they have to be applied
+// The schema version is the amount of migrations that have been applied, so only ever add to the end
+var migrations = []migration{
+	{"normalise users and backfill guild defaults", migrateNormaliseDocuments},
+}
+
+// The schema version this build of the bot expects
+func SchemaVersion() int {
+	return len(migrations)
+}
+
+// Gets the schema version the database is at, databases from before versioning are at 0
+func GetSchemaVersion() (int, error) {
+	var schema schemaData
+
+	err := MongoClient.MetaCollection().FindOne(context.Background(), bson.D{{"_id", schemaId}}).Decode(&schema)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return schema.Version, nil
+}
+
+func setSchemaVersion(version int) error {
+	filter := bson.D{{"_id", schemaId}}
+	update := bson.D{{"$set", bson.D{{"version", version}, {"updatedAt", time.Now().UTC()}}}}
+
+	_, err := MongoClient.MetaCollection().UpdateOne(context.Background(), filter, update, options.Update().SetUpsert(true))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Applies every migration the database hasn't had yet, this has to happen before anything reads documents
+func RunMigrations() error {
+	version, err := GetSchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	// Running an older build against a newer database could undo migrations
+	if version > SchemaVersion() {
+		return errors.New(fmt.Sprint("Database schema version ", version, " is newer than this build supports (", SchemaVersion(), ")"))
+	}
+
+	for version < SchemaVersion() {
+		next := migrations[version]
+		log.Println("Migrating database to schema version", version+1, "-", next.description, "...")
+
+		err := next.run()
+		if err != nil {
+			return err
+		}
+
+		// Record every step so a failed migration doesn't rerun the ones before it
+		version++
+		err = setSchemaVersion(version)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sets the given fields on the documents of a collection that don't have them yet
+// Migrations pass the values as they were at the time, so that later changes to the defaults don't change what they do
+func backfillFields(collection *mongo.Collection, defaults bson.D) error {
+	for _, field := range defaults {
+		filter := bson.D{{field.Key, bson.D{{"$exists", false}}}}
+
+		_, err := collection.UpdateMany(context.Background(), filter, bson.D{{"$set", bson.D{field}}})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Version 1
+// User activity used to be stored as lastactivity because of a broken struct tag, it's lastActivity now
+// Users and guilds get every field that was added since they were created
+func migrateNormaliseDocuments() error {
+	users := MongoClient.UsersCollection()
+
+	_, err := users.UpdateMany(context.Background(),
+		bson.D{{"lastactivity", bson.D{{"$exists", true}}}},
+		bson.D{{"$rename", bson.D{{"lastactivity", "lastActivity"}}}})
+	if err != nil {
+		return err
+	}
+
+	err = backfillFields(users, bson.D{
+		{"activitySource", ""},
+		{"trackedSince", time.Time{}},
+		{"immune", false},
+		{"immuneUntil", time.Time{}},
+		{"lastWarned", time.Time{}},
+		{"warnings", int64(0)},
+		{"scoreWarned", time.Time{}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Guilds only had their messages, inactivity days and warning offset, everything else gets the defaults
+	return backfillFields(MongoClient.ServersCollection(), bson.D{
+		{"kickmsg", "**You have been yeeted from %server% due to being inactive for %time% days.**"},
+		{"warnmsg", "**You will be kicked from %server% in %time% days due to inactivity unless you display some activity.**"},
+		{"dayInactivity", int64(30)},
+		{"lastUpdated", time.Time{}},
+		{"warnOffset", int64(-1)},
+		{"lastBatch", ""},
+		{"restoreRoles", false},
+		{"restoreWindow", int64(14)},
+		{"maxKicks", int64(100)},
+		{"maxKickPercent", int64(20)},
+		{"awaitingConfirm", false},
+		{"logChannel", ""},
+		{"reviewKicks", false},
+		{"scorePolicy", false},
+		{"scoreThreshold", float64(5)},
+		{"scoreWindow", int64(60)},
+		{"scoreSince", time.Time{}},
+		{"minVoiceMinutes", int64(5)},
+		{"channelAllowList", false},
+		{"minMessageLength", int64(0)},
+		{"ignoreEmojiOnly", false},
+		{"ignoreRepeatedWord", false},
+		{"ignoreDuplicates", false},
+		{"ignoredChannels", nil},
+		{"ignoredCategories", nil},
+		{"ignoreArchivedThreads", false},
+		{"timezone", ""},
+		{"runTime", int64(-1)},
+		{"paused", false},
+		{"pausedUntil", time.Time{}},
+		{"activityWeights", nil},
+	})
+}
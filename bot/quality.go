@@ -0,0 +1,128 @@
+package bot
+
+import (
+	"hash/fnv"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Matches custom emoji like <:yeet:123> and <a:yeet:123>
+var customEmojiRegex = regexp.MustCompile(`<a?:\w+:\d+>`)
+
+// How long a message is remembered for, posting it again after that counts again
+const duplicateMessageMemory = 24 * time.Hour
+
+type rememberedMessage struct {
+	sum  uint64
+	sent time.Time
+}
+
+// The last message of every user in guilds that ignore duplicates, by guild and user
+var (
+	lastMessages       = make(map[string]rememberedMessage)
+	lastMessagesPruned time.Time
+	lastMessagesLock   sync.Mutex
+)
+
+// Checks whether a message is good enough to count as activity under the guild's rules
+// Messages with attachments always count, a picture says more than the length of its text
+func messageCounts(guildData *GuildData, userId, content string, hasAttachments bool) bool {
+	content = strings.TrimSpace(content)
+
+	// Remember the message even if it doesn't count, so that posting it again doesn't either
+	duplicate := guildData.IgnoreDuplicates && isDuplicateMessage(guildData.GuildId, userId, content)
+
+	if hasAttachments {
+		return true
+	}
+
+	if int64(utf8.RuneCountInString(content)) < guildData.MinMessageLength {
+		return false
+	}
+
+	if guildData.IgnoreEmojiOnly && isEmojiOnly(content) {
+		return false
+	}
+
+	if guildData.IgnoreRepeatedWord && isRepeatedWord(content) {
+		return false
+	}
+
+	if duplicate {
+		return false
+	}
+	return true
+}
+
+// Checks whether the message consists of nothing but emoji
+func isEmojiOnly(content string) bool {
+	if content == "" {
+		return false
+	}
+
+	stripped := customEmojiRegex.ReplaceAllString(content, "")
+
+	for _, char := range stripped {
+
+		// Emoji are symbols, glued together by joiners and variation selectors
+		if unicode.IsSpace(char) || unicode.Is(unicode.So, char) || unicode.Is(unicode.Sk, char) ||
+			unicode.Is(unicode.Mn, char) || unicode.Is(unicode.Cf, char) {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// Checks whether the message is a single word said over and over, like "lol lol lol" or "aaaaaa"
+func isRepeatedWord(content string) bool {
+	words := strings.Fields(strings.ToLower(content))
+	if len(words) == 0 {
+		return false
+	}
+
+	for _, word := range words[1:] {
+		if word != words[0] {
+			return false
+		}
+	}
+
+	if len(words) > 1 {
+		return true
+	}
+
+	// A single word made of a single repeated character
+	first, _ := utf8.DecodeRuneInString(words[0])
+	return utf8.RuneCountInString(words[0]) > 1 && strings.Trim(words[0], string(first)) == ""
+}
+
+// Checks whether the message is the same as the user's previous one and remembers it for next time
+func isDuplicateMessage(guildId, userId, content string) bool {
+	hash := fnv.New64a()
+	hash.Write([]byte(strings.ToLower(content)))
+	sum := hash.Sum64()
+
+	key := guildId + ":" + userId
+	now := time.Now()
+
+	lastMessagesLock.Lock()
+	defer lastMessagesLock.Unlock()
+
+	previous, ok := lastMessages[key]
+	lastMessages[key] = rememberedMessage{sum, now}
+
+	// Forget the messages that are too old every so often so the map doesn't keep growing
+	if now.Sub(lastMessagesPruned) >= time.Hour {
+		for messageKey, message := range lastMessages {
+			if now.Sub(message.sent) >= duplicateMessageMemory {
+				delete(lastMessages, messageKey)
+			}
+		}
+		lastMessagesPruned = now
+	}
+	return ok && previous.sum == sum && now.Sub(previous.sent) < duplicateMessageMemory
+}
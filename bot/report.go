@@ -0,0 +1,243 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	discord "github.com/bwmarrin/discordgo"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// How many members are listed in the message itself, the rest are only in the attached file
+const reportPreviewSize = 15
+
+type reportEntry struct {
+	UserId       string
+	Name         string
+	DaysInactive int64
+	LastActivity time.Time
+	Source       string
+	Status       string
+	KickDate     string
+}
+
+// Works out where every tracked member of a guild stands, most inactive first
+// Only members inactive for at least the given days are included
+func buildReport(session *discord.Session, guild *discord.Guild, guildData *GuildData, minDays int64) ([]reportEntry, error) {
+	var entries []reportEntry
+
+	// Activity that's still waiting to be written counts too
+	err := FlushActivity()
+	if err != nil {
+		log.Println(err)
+	}
+
+	now := time.Now()
+	currentDay := guildData.Day(now)
+	location := guildData.Location()
+
+	// Users waiting for review have their own status
+	queued := make(map[string]bool)
+	pending, err := GetPendingKicks(guild.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, kick := range pending {
+		queued[kick.UserId] = true
+	}
+
+	cur, err := MongoClient.UsersCollection().Find(context.Background(), bson.D{{"guildId", guild.ID}})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(context.Background())
+
+	for cur.Next(context.Background()) {
+		var user UserData
+		err := cur.Decode(&user)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		// The owner and the bot are never kicked
+		if user.UserId == guild.OwnerID || user.UserId == SelfId {
+			continue
+		}
+
+		daysInactive := currentDay - guildData.Day(user.LastActivity)
+		if daysInactive < minDays {
+			continue
+		}
+
+		entry := reportEntry{
+			UserId:       user.UserId,
+			DaysInactive: daysInactive,
+			LastActivity: user.LastActivity,
+			Source:       user.ActivitySource,
+			KickDate:     kickDate(guildData, &user, now),
+		}
+
+		switch {
+		case user.Immune:
+			entry.Status = "immune"
+			entry.KickDate = ""
+		case user.ImmuneUntil.After(now):
+			entry.Status = fmt.Sprint("immune until ", user.ImmuneUntil.In(location).Format("2006-01-02"))
+		case queued[user.UserId]:
+			entry.Status = "waiting for review"
+		case daysInactive > guildData.MaxDayInactivity && user.LastWarned.After(user.LastActivity):
+			entry.Status = "due for kick"
+		case daysInactive > guildData.MaxDayInactivity:
+			entry.Status = "due for warning"
+		case user.LastWarned.After(user.LastActivity):
+			entry.Status = "warned"
+		default:
+			entry.Status = "active"
+		}
+
+		member, err := session.State.Member(guild.ID, user.UserId)
+		if err == nil && member.User != nil {
+			entry.Name = member.User.String()
+		}
+
+		entries = append(entries, entry)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].DaysInactive > entries[j].DaysInactive
+	})
+	return entries, nil
+}
+
+// Works out the day a user gets kicked for their inactivity, following the same rules as a run
+// It's left empty when it can't be known: while runs are stopped or when the score policy can kick earlier
+func kickDate(guildData *GuildData, user *UserData, now time.Time) string {
+	kickDay, ok := kickDayOf(guildData, user, now)
+	if !ok {
+		return ""
+	}
+
+	unixDay := int64(24 * time.Hour.Seconds())
+	return time.Unix(kickDay*unixDay, 0).UTC().Format("2006-01-02")
+}
+
+// Same as kickDate, but as a day number in the guild's timezone, false when it can't be known
+func kickDayOf(guildData *GuildData, user *UserData, now time.Time) (int64, bool) {
+	if InMaintenance() || guildData.IsPaused(now) || guildData.ScorePolicyActive(now) {
+		return 0, false
+	}
+
+	// Today's run might have happened already
+	today := guildData.Day(now)
+	firstRun := today
+	if guildData.Day(guildData.LastUpdated) == today {
+		firstRun++
+	}
+
+	// Users get kicked on the first day they're over the limit
+	lastActive := guildData.Day(user.LastActivity)
+	kickDay := lastActive + guildData.MaxDayInactivity + 1
+	if kickDay < firstRun {
+		kickDay = firstRun
+	}
+
+	// Users that won't get their last warning in time get warned by one run and kicked by the next
+	_, lastDay := guildData.WarningDays()
+	if !user.LastWarned.After(user.LastActivity) && lastActive+lastDay < firstRun {
+		kickDay++
+	}
+	return kickDay, true
+}
+
+// Writes the report as a CSV file
+func reportCSV(entries []reportEntry) (*bytes.Buffer, error) {
+	buffer := new(bytes.Buffer)
+	writer := csv.NewWriter(buffer)
+
+	err := writer.Write([]string{"userId", "name", "daysInactive", "lastActivity", "source", "status", "kickDate"})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		err := writer.Write([]string{
+			entry.UserId,
+			entry.Name,
+			strconv.FormatInt(entry.DaysInactive, 10),
+			entry.LastActivity.UTC().Format(time.RFC3339),
+			entry.Source,
+			entry.Status,
+			entry.KickDate,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	writer.Flush()
+	return buffer, writer.Error()
+}
+
+func handleReport(session *discord.Session, data *discord.MessageCreate, guild *discord.Guild, guildData *GuildData, args []string) {
+	minDays := int64(0)
+	if len(args) > 0 {
+		value, err := strconv.ParseInt(args[0], 0, 64)
+		if err != nil {
+			session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**", err.Error(), "**"))
+			return
+		}
+		minDays = value
+	}
+
+	entries, err := buildReport(session, guild, guildData, minDays)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	if len(entries) == 0 {
+		session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**Nobody has been inactive for ", minDays, " days or more**"))
+		return
+	}
+
+	lines := []string{fmt.Sprint("**", len(entries), " members inactive for ", minDays, " days or more:**")}
+	for i, entry := range entries {
+		if i == reportPreviewSize {
+			lines = append(lines, fmt.Sprint("...and ", len(entries)-reportPreviewSize, " more, see the attached file"))
+			break
+		}
+
+		line := fmt.Sprint("<@", entry.UserId, "> - ", entry.DaysInactive, " days, ", entry.Status)
+		if entry.KickDate != "" && entry.Status != "immune" && entry.Status != "due for kick" {
+			line += fmt.Sprint(", kicked on ", entry.KickDate)
+		}
+		lines = append(lines, line)
+	}
+
+	file, err := reportCSV(entries)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	// The preview is short enough to always fit in a single message
+	_, err = session.ChannelMessageSendComplex(data.ChannelID, &discord.MessageSend{
+		Content:         chunkLines(lines)[0],
+		AllowedMentions: &discord.MessageAllowedMentions{},
+		Files: []*discord.File{{
+			Name:        "inactivity-report.csv",
+			ContentType: "text/csv",
+			Reader:      file,
+		}},
+	})
+	if err != nil {
+		log.Println(err)
+	}
+}
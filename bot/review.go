@@ -0,0 +1,313 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	discord "github.com/bwmarrin/discordgo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type PendingKick struct {
+	GuildId   string    `bson:"guildId"`
+	UserId    string    `bson:"userId"`
+	DayOffset int64     `bson:"dayOffset"`
+	QueuedAt  time.Time `bson:"queuedAt"`
+
+	// What the kick is for, entries from before reasons were stored have none
+	Reason string `bson:"reason"`
+}
+
+// Adds a user to the review queue, users already in the queue keep their place
+// Returns whether the user is new to the queue
+func QueueKick(guildId, userId string, dayOffset int64, reason string) (bool, error) {
+	filter := bson.D{{"guildId", guildId}, {"userId", userId}}
+	update := bson.D{
+		{"$set", bson.D{{"dayOffset", dayOffset}, {"reason", reason}}},
+		{"$setOnInsert", bson.D{{"queuedAt", time.Now().UTC()}}},
+	}
+
+	result, err := MongoClient.ReviewsCollection().UpdateOne(context.Background(), filter, update, options.Update().SetUpsert(true))
+	if err != nil {
+		return false, err
+	}
+	return result.UpsertedCount > 0, nil
+}
+
+func GetPendingKick(guildId, userId string) (*PendingKick, error) {
+	var pending *PendingKick = new(PendingKick)
+
+	err := MongoClient.ReviewsCollection().FindOne(context.Background(), bson.D{{"guildId", guildId}, {"userId", userId}}).Decode(pending)
+	if err != nil {
+		return nil, err
+	}
+	return pending, nil
+}
+
+// Gets the review queue of a guild, most inactive users first
+func GetPendingKicks(guildId string) ([]PendingKick, error) {
+	var pending []PendingKick
+
+	opts := options.Find().SetSort(bson.D{{"dayOffset", -1}})
+	cur, err := MongoClient.ReviewsCollection().Find(context.Background(), bson.D{{"guildId", guildId}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(context.Background())
+
+	err = cur.All(context.Background(), &pending)
+	if err != nil {
+		return nil, err
+	}
+	return pending, nil
+}
+
+func DeletePendingKick(guildId, userId string) error {
+	_, err := MongoClient.ReviewsCollection().DeleteOne(context.Background(), bson.D{{"guildId", guildId}, {"userId", userId}})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func DeletePendingKicksForGuild(guildId string) error {
+	_, err := MongoClient.ReviewsCollection().DeleteMany(context.Background(), bson.D{{"guildId", guildId}})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Puts the users that would've been kicked in the review queue and tells the admins about it
+func queueForReview(session *discord.Session, guild *discord.Guild, guildData *GuildData, kicks []inactiveUser) {
+	var lines []string
+
+	for _, kick := range kicks {
+		queued, err := QueueKick(kick.user.GuildId, kick.user.UserId, kick.dayOffset, kick.reason)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		// Users that were already waiting have been posted before
+		if !queued {
+			continue
+		}
+
+		lines = append(lines, fmt.Sprint("<@", kick.user.UserId, "> - inactive for ", kick.dayOffset, " days"))
+	}
+
+	if len(lines) == 0 {
+		return
+	}
+
+	lines = append([]string{fmt.Sprint("**", len(lines), " more users are waiting for review:**")}, lines...)
+	lines = append(lines, fmt.Sprint("Use `", cmdTag, " review approve (mention/all)` to kick or `", cmdTag, " review deny (mention) [days]` to keep them."))
+
+	for _, message := range chunkLines(lines) {
+		logToGuild(session, guild, guildData, message)
+	}
+}
+
+func handleReview(session *discord.Session, data *discord.MessageCreate, guild *discord.Guild, guildData *GuildData, args []string) {
+
+	// List the queue
+	if len(args) == 0 {
+		pending, err := GetPendingKicks(guild.ID)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		if len(pending) == 0 {
+			session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**The review queue is empty (review mode is set to: ", guildData.ReviewKicks, ")**"))
+			return
+		}
+
+		lines := []string{fmt.Sprint("**", len(pending), " users are waiting for review:**")}
+		for _, kick := range pending {
+			lines = append(lines, fmt.Sprint("<@", kick.UserId, "> - inactive for ", kick.DayOffset, " days"))
+		}
+
+		for _, message := range chunkLines(lines) {
+			sendQuiet(session, data.ChannelID, message)
+		}
+		return
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "approve":
+		if len(args) < 2 {
+			session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**Usage: ", cmdTag, " review approve (mention/all) [confirm]**"))
+			return
+		}
+
+		// Approved users stay in the queue until kicks can happen again
+		if stopped := kicksStopped(guildData, time.Now()); stopped != "" {
+			session.ChannelMessageSend(data.ChannelID, stopped)
+			return
+		}
+
+		var pending []PendingKick
+		if strings.ToLower(args[1]) == "all" {
+			all, err := GetPendingKicks(guild.ID)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			pending = all
+
+			// A queue filled by broken activity tracking shouldn't be kicked in one go by accident
+			confirmed := len(args) > 2 && strings.ToLower(args[2]) == "confirm"
+			if !confirmed && guildData.KickLimitExceeded(len(pending), guild.MemberCount) {
+				session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**Kicking all ", len(pending), " users would go over the kick limit, use `", cmdTag, " review approve all confirm` to kick them anyways**"))
+				return
+			}
+		} else {
+			member := mentionToMember(session, guild.ID, args[1])
+			if member == nil {
+
+				// User was not found
+				session.ChannelMessageSend(data.ChannelID, "User not found")
+				return
+			}
+
+			kick, err := GetPendingKick(guild.ID, member.User.ID)
+			if err != nil {
+				session.ChannelMessageSend(data.ChannelID, "**That user is not waiting for review**")
+				return
+			}
+			pending = append(pending, *kick)
+		}
+
+		kicked, skipped := approveKicks(session, guild, guildData, pending)
+		session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**Kicked ", kicked, " users, ", skipped, " became active or immune in the meantime**"))
+		break
+
+	case "deny":
+		if len(args) < 2 {
+			session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**Usage: ", cmdTag, " review deny (mention) [days]**"))
+			return
+		}
+
+		member := mentionToMember(session, guild.ID, args[1])
+		if member == nil {
+
+			// User was not found
+			session.ChannelMessageSend(data.ChannelID, "User not found")
+			return
+		}
+
+		guildUser, err := guildData.GetUser(member.User.ID)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		// With a day count the user becomes temporarily immune, otherwise their activity clock starts over
+		if len(args) > 2 {
+			days, err := strconv.ParseInt(args[2], 0, 64)
+			if err != nil {
+				session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**", err.Error(), "**"))
+				return
+			}
+
+			if days < 1 {
+				session.ChannelMessageSend(data.ChannelID, "**Immunity has to last at least 1 day**")
+				return
+			}
+
+			err = guildUser.UpdateImmuneUntil(time.Now().UTC().AddDate(0, 0, int(days)))
+			if err != nil {
+				log.Println(err)
+				return
+			}
+		} else {
+			err = guildUser.UpdateActivity(time.Now().UTC(), SourceManual)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+		}
+
+		err = DeletePendingKick(guild.ID, member.User.ID)
+		if err != nil {
+			log.Println(err)
+		}
+
+		if len(args) > 2 {
+			session.ChannelMessageSend(data.ChannelID, fmt.Sprint(member.Mention(), " is immune until ", guildUser.ImmuneUntil.Format("2006-01-02")))
+			return
+		}
+		session.ChannelMessageSend(data.ChannelID, fmt.Sprint(member.Mention(), " had their activity reset"))
+		break
+
+	default:
+		value, err := parseToggle(args[0])
+		if err != nil {
+			session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**", err.Error(), "**"))
+			return
+		}
+
+		err = guildData.SetReviewKicks(value)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**Review mode for this server set to: ", guildData.ReviewKicks, "**"))
+		break
+	}
+}
+
+// Kicks the approved users, skipping anyone who became active or immune since they were queued
+func approveKicks(session *discord.Session, guild *discord.Guild, guildData *GuildData, pending []PendingKick) (int, int) {
+	kicked := 0
+	skipped := 0
+
+	// Approved kicks are a batch of their own so they can be undone as well
+	batchId := newBatchId()
+	now := time.Now().UTC()
+
+	// Activity that's still waiting to be written counts too
+	err := FlushActivity()
+	if err != nil {
+		log.Println(err)
+	}
+
+	for _, kick := range pending {
+		err := DeletePendingKick(kick.GuildId, kick.UserId)
+		if err != nil {
+			log.Println(err)
+		}
+
+		user, err := GetUser(kick.GuildId, kick.UserId)
+		if err != nil || user.IsImmune(now) || user.LastActivity.After(kick.QueuedAt) {
+			skipped++
+			continue
+		}
+
+		reason := kick.Reason
+		if reason == "" {
+			reason = kickReason(guildData)
+		}
+
+		err = yeet(session, kick.GuildId, kick.UserId, kickMessage(guild, guildData), reason, batchId)
+		if err == nil {
+			kicked++
+		}
+	}
+
+	// Remember the batch so that it can be undone
+	if kicked > 0 {
+		err := guildData.SetLastBatch(batchId)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+	return kicked, skipped
+}
@@ -0,0 +1,64 @@
+package bot
+
+import (
+	"fmt"
+	"sync"
+)
+
+// What happened to the members of a guild during a run
+type RunReport struct {
+	Checked int
+	Warned  int
+	Kicked  int
+	Queued  int
+	Immune  int
+	Errored int
+
+	// The safety cap stopped the kicks
+	Capped bool
+
+	// Nothing was sent or kicked, the counts are what would have happened
+	Dry bool
+}
+
+func (self RunReport) String() string {
+	if self.Dry {
+		result := fmt.Sprint("**Dry run: checked ", self.Checked, ", ", self.Warned, " would be warned, ", self.Kicked, " would be kicked, ",
+			self.Queued, " would be queued for review, ", self.Immune, " skipped as immune, ", self.Errored, " errors**")
+		if self.Capped {
+			result += "\n**The safety cap would stop the kicks**"
+		}
+		return result
+	}
+
+	result := fmt.Sprint("**Run finished: checked ", self.Checked, ", warned ", self.Warned, ", kicked ", self.Kicked, ", ",
+		self.Queued, " queued for review, ", self.Immune, " skipped as immune, ", self.Errored, " errors**")
+	if self.Capped {
+		result += "\n**The safety cap stopped the kicks**"
+	}
+	return result
+}
+
+var (
+	runningGuilds     = make(map[string]bool)
+	runningGuildsLock sync.Mutex
+)
+
+// Marks a guild as being run, returns false if it already is
+func lockGuildRun(guildId string) bool {
+	runningGuildsLock.Lock()
+	defer runningGuildsLock.Unlock()
+
+	if runningGuilds[guildId] {
+		return false
+	}
+	runningGuilds[guildId] = true
+	return true
+}
+
+func unlockGuildRun(guildId string) {
+	runningGuildsLock.Lock()
+	defer runningGuildsLock.Unlock()
+
+	delete(runningGuilds, guildId)
+}
@@ -0,0 +1,221 @@
+package bot
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	discord "github.com/bwmarrin/discordgo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// How many guilds get checked at the same time
+const schedulerWorkers = 4
+
+// How often every guild gets checked for inactive users
+const scheduleInterval = 1 * time.Hour
+
+// Up to how much time gets added to or taken off a guild's next run, so guilds don't all run at once
+const scheduleJitter = 10 * time.Minute
+
+// How often the scheduler looks for guilds that are due
+const schedulerTick = 1 * time.Minute
+
+// Time between handing out guilds to the workers, to stay clear of Discord's rate limits
+const scheduleSpacing = 2 * time.Second
+
+// How often the server count gets updated and old activity gets pruned
+const maintenanceInterval = 1 * time.Hour
+
+type scheduler struct {
+	session *discord.Session
+	jobs    chan string
+
+	lock    sync.Mutex
+	nextRun map[string]time.Time
+	running map[string]bool
+
+	lastMaintenance time.Time
+}
+
+var (
+	guildScheduler *scheduler
+	schedulerOnce  sync.Once
+)
+
+// Starts checking every guild for inactive users in the background
+func StartScheduler(session *discord.Session) {
+	schedulerOnce.Do(func() {
+		rand.Seed(time.Now().UnixNano())
+
+		guildScheduler = &scheduler{
+			session: session,
+			jobs:    make(chan string),
+			nextRun: make(map[string]time.Time),
+			running: make(map[string]bool),
+		}
+
+		for i := 0; i < schedulerWorkers; i++ {
+			go guildScheduler.work()
+		}
+		go guildScheduler.loop()
+	})
+}
+
+// Gets when a guild will be checked next, if the scheduler knows about it yet
+func NextRun(guildId string) (time.Time, bool) {
+	if guildScheduler == nil {
+		return time.Time{}, false
+	}
+
+	guildScheduler.lock.Lock()
+	defer guildScheduler.lock.Unlock()
+
+	next, ok := guildScheduler.nextRun[guildId]
+	return next, ok
+}
+
+// Gets a random duration between -max and max
+func jitter(max time.Duration) time.Duration {
+	return time.Duration(rand.Int63n(int64(2*max))) - max
+}
+
+func (self *scheduler) loop() {
+	for {
+		if time.Since(self.lastMaintenance) >= maintenanceInterval {
+			self.maintenance()
+		}
+
+		// Guilds keep their place while the bot is in maintenance mode
+		if InMaintenance() {
+			time.Sleep(schedulerTick)
+			continue
+		}
+
+		for _, guildId := range self.dueGuilds() {
+			self.jobs <- guildId
+			time.Sleep(scheduleSpacing)
+		}
+
+		time.Sleep(schedulerTick)
+	}
+}
+
+func (self *scheduler) maintenance() {
+	self.lastMaintenance = time.Now()
+
+	// Update the server count
+	UpdateServerCount(self.session)
+
+	// Get rid of activity scores nobody needs anymore
+	err := PruneActivity()
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// Gets the guilds whose next run has come, new guilds get a random time within the next interval
+func (self *scheduler) dueGuilds() []string {
+	var due []string
+
+	opts := options.Find().SetProjection(bson.D{{"guildId", 1}})
+	cur, err := MongoClient.ServersCollection().Find(context.Background(), bson.D{}, opts)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	defer cur.Close(context.Background())
+
+	now := time.Now()
+	seen := make(map[string]bool)
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	for cur.Next(context.Background()) {
+		var result struct {
+			GuildId string `bson:"guildId"`
+		}
+
+		// A broken document shouldn't keep the other guilds from running
+		err := cur.Decode(&result)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		seen[result.GuildId] = true
+
+		next, ok := self.nextRun[result.GuildId]
+		if !ok {
+			self.nextRun[result.GuildId] = now.Add(time.Duration(rand.Int63n(int64(scheduleInterval))))
+			continue
+		}
+
+		if !self.running[result.GuildId] && !next.After(now) {
+			self.running[result.GuildId] = true
+			due = append(due, result.GuildId)
+		}
+	}
+
+	// Forget the guilds the bot isn't in anymore
+	for guildId := range self.nextRun {
+		if !seen[guildId] && !self.running[guildId] {
+			delete(self.nextRun, guildId)
+			delete(self.running, guildId)
+		}
+	}
+	return due
+}
+
+func (self *scheduler) work() {
+	for guildId := range self.jobs {
+		guildData := self.runGuild(guildId)
+
+		// Guilds with a run time get checked right when it comes around
+		now := time.Now()
+		next := now.Add(scheduleInterval + jitter(scheduleJitter))
+		if guildData != nil && guildData.RunTime >= 0 {
+			scheduled := guildData.NextScheduledRun(now)
+			if scheduled.Before(next) {
+				next = scheduled
+			}
+		}
+
+		self.lock.Lock()
+		self.running[guildId] = false
+		self.nextRun[guildId] = next
+		self.lock.Unlock()
+	}
+}
+
+// Checks a single guild, whatever goes wrong stays with this guild
+// Returns the guild's data if it could be loaded
+func (self *scheduler) runGuild(guildId string) (guildData *GuildData) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Println("Checking guild", guildId, "panicked:", err)
+			log.Println(string(debug.Stack()))
+		}
+	}()
+
+	guildData, err := GetGuild(guildId)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	// Get Discord guild
+	guild, err := GetDiscordGuild(self.session, guildId)
+	if err != nil {
+		log.Println(err)
+		return guildData
+	}
+
+	// Handle kicking for the guild
+	HandleKickForGuild(self.session, guild, *guildData)
+	return guildData
+}
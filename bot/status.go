@@ -0,0 +1,212 @@
+package bot
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	discord "github.com/bwmarrin/discordgo"
+)
+
+// How many past kicks are shown in a user's status
+const statusKickHistory = 5
+
+// How long members have to wait between checking their own status
+const selfCheckCooldown = 1 * time.Minute
+
+var (
+	selfChecks     = make(map[string]time.Time)
+	selfChecksLock sync.Mutex
+)
+
+// Describes where a user stands under the guild's current settings, one line per fact
+func userStatus(guild *discord.Guild, guildData *GuildData, user *UserData) []string {
+	var lines []string
+
+	now := time.Now()
+	location := guildData.Location()
+	dateFormat := "2006-01-02 15:04 MST"
+
+	source := user.ActivitySource
+	if source == "" {
+		source = "unknown"
+	}
+	lines = append(lines, fmt.Sprint("Last activity: ", user.LastActivity.In(location).Format(dateFormat), " (", source, ")"))
+
+	daysInactive := guildData.Day(now) - guildData.Day(user.LastActivity)
+	lines = append(lines, fmt.Sprint("Days inactive: ", daysInactive))
+
+	// Immune users never get warned or kicked, so the countdown doesn't matter
+	switch {
+	case user.Immune:
+		lines = append(lines, "Immunity: permanent")
+	case user.ImmuneUntil.After(now):
+		lines = append(lines, fmt.Sprint("Immunity: until ", user.ImmuneUntil.In(location).Format(dateFormat)))
+	case user.UserId == guild.OwnerID:
+		lines = append(lines, "Immunity: server owner")
+	default:
+		lines = append(lines, "Immunity: none")
+
+		firstWarning, _ := guildData.WarningDays()
+		if daysInactive < firstWarning {
+			lines = append(lines, fmt.Sprint("Days until first warning: ", firstWarning-daysInactive))
+		} else {
+			lines = append(lines, "Days until first warning: already passed")
+		}
+
+		// Worked out the same way as the report, so both always agree
+		kickDay, known := kickDayOf(guildData, user, now)
+		if !known {
+			lines = append(lines, "Days until kick: unknown while runs are stopped or the score policy is on")
+		} else if daysLeft := kickDay - guildData.Day(now); daysLeft > 0 {
+			lines = append(lines, fmt.Sprint("Days until kick: ", daysLeft, " (", kickDate(guildData, user, now), ")"))
+		} else {
+			lines = append(lines, "Days until kick: due with today's run")
+		}
+	}
+
+	if user.Warnings > 0 {
+		lines = append(lines, fmt.Sprint("Warnings sent: ", user.Warnings, ", last on ", user.LastWarned.In(location).Format(dateFormat)))
+	} else {
+		lines = append(lines, "Warnings sent: 0")
+	}
+
+	_, err := GetPendingKick(guild.ID, user.UserId)
+	if err == nil {
+		lines = append(lines, "Waiting for review")
+	}
+	return lines
+}
+
+// Describes the past kicks of a user, newest first
+func kickHistory(guildData *GuildData, userId string) []string {
+	var lines []string
+
+	records, err := GetKickHistory(guildData.GuildId, userId, statusKickHistory)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	if len(records) == 0 {
+		return []string{"Kick history: none"}
+	}
+
+	lines = append(lines, "Kick history:")
+	for _, record := range records {
+		kind := "manual"
+		if record.Automated {
+			kind = "automated"
+		}
+
+		line := fmt.Sprint(" - ", record.KickedAt.In(guildData.Location()).Format("2006-01-02"), " (", kind, ")")
+		if record.Restored {
+			line += ", roles restored on rejoin"
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func handleStatus(session *discord.Session, data *discord.MessageCreate, guild *discord.Guild, guildData *GuildData, args []string) {
+	if len(args) != 1 {
+		session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**Usage: ", cmdTag, " status (mention)**"))
+		return
+	}
+
+	member := mentionToMember(session, guild.ID, args[0])
+	if member == nil {
+
+		// User was not found
+		session.ChannelMessageSend(data.ChannelID, "User not found")
+		return
+	}
+
+	// Activity that's still waiting to be written counts too
+	err := FlushActivity()
+	if err != nil {
+		log.Println(err)
+	}
+
+	lines := []string{fmt.Sprint("**Status of ", member.Mention(), ":**")}
+
+	user, err := guildData.GetUser(member.User.ID)
+	if err != nil {
+		lines = append(lines, fmt.Sprint("Not tracked, run `", cmdTag, " forceadd` to track everyone"))
+	} else {
+		lines = append(lines, userStatus(guild, guildData, user)...)
+	}
+	lines = append(lines, kickHistory(guildData, member.User.ID)...)
+
+	for _, message := range chunkLines(lines) {
+		sendQuiet(session, data.ChannelID, message)
+	}
+}
+
+// Checks whether a member may check their own status again and remembers that they did
+func allowSelfCheck(guildId, userId string) bool {
+	selfChecksLock.Lock()
+	defer selfChecksLock.Unlock()
+
+	key := guildId + "/" + userId
+	if last, ok := selfChecks[key]; ok && time.Since(last) < selfCheckCooldown {
+		return false
+	}
+
+	// Forget the checks that are past their cooldown so the map doesn't keep growing
+	for checkKey, last := range selfChecks {
+		if time.Since(last) >= selfCheckCooldown {
+			delete(selfChecks, checkKey)
+		}
+	}
+
+	selfChecks[key] = time.Now()
+	return true
+}
+
+// Lets a member see their own status, the answer goes to their DMs so nobody else sees it
+func handleMe(session *discord.Session, data *discord.MessageCreate, guild *discord.Guild) {
+
+	// Keep the channel clean
+	session.ChannelMessageDelete(data.ChannelID, data.ID)
+
+	if !allowSelfCheck(guild.ID, data.Author.ID) {
+		return
+	}
+
+	guildData, err := GetGuild(guild.ID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	channel, err := session.UserChannelCreate(data.Author.ID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	// Activity that's still waiting to be written counts too
+	err = FlushActivity()
+	if err != nil {
+		log.Println(err)
+	}
+
+	lines := []string{fmt.Sprint("**Your status on ", guild.Name, ":**")}
+
+	user, err := guildData.GetUser(data.Author.ID)
+	if err != nil {
+		lines = append(lines, "You aren't tracked yet, your next message or voice activity will change that")
+	} else {
+		lines = append(lines, userStatus(guild, guildData, user)...)
+	}
+
+	for _, message := range chunkLines(lines) {
+		_, err := sendQuiet(session, channel.ID, message)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+	}
+}
@@ -0,0 +1,501 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	discord "github.com/bwmarrin/discordgo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Files bigger than this aren't imported
+const maxImportSize = 8 * 1024 * 1024
+
+// Downloads files to import, giving up on them if discord takes too long
+var importClient = &http.Client{Timeout: 30 * time.Second}
+
+// Everything the bot knows about a guild, as stored in an export file
+type GuildExport struct {
+	SchemaVersion int            `json:"schemaVersion"`
+	ExportedAt    time.Time      `json:"exportedAt"`
+	Guild         *GuildData     `json:"guild,omitempty"`
+	Users         []ExportedUser `json:"users"`
+
+	// The columns a CSV file had, nil when the file has every field
+	Columns map[string]bool `json:"-"`
+}
+
+// Checks whether the file had a field, files that don't have one leave it alone when merging
+func (self *GuildExport) HasField(name string) bool {
+	return self.Columns == nil || self.Columns[name]
+}
+
+// Makes sure nobody in the file would become immune, activity from the future would never run out
+func (self *GuildExport) checkUsers(now time.Time) error {
+	for _, user := range self.Users {
+		if user.LastActivity.After(now) {
+			return errors.New(fmt.Sprint("User ", user.UserId, " has activity in the future (", user.LastActivity.Format(time.RFC3339), ")"))
+		}
+	}
+	return nil
+}
+
+type ExportedUser struct {
+	UserId         string    `json:"userId"`
+	LastActivity   time.Time `json:"lastActivity"`
+	ActivitySource string    `json:"activitySource"`
+	TrackedSince   time.Time `json:"trackedSince"`
+	Immune         bool      `json:"immune"`
+	ImmuneUntil    time.Time `json:"immuneUntil"`
+	Warnings       int64     `json:"warnings"`
+	LastWarned     time.Time `json:"lastWarned"`
+}
+
+// The columns of a CSV export, only userId and lastActivity are needed to import one
+var exportColumns = []string{"userId", "lastActivity", "activitySource", "trackedSince", "immune", "immuneUntil", "warnings", "lastWarned"}
+
+// Gets the settings and every tracked user of a guild
+func ExportGuild(guildId string) (*GuildExport, error) {
+	export := &GuildExport{SchemaVersion: SchemaVersion(), ExportedAt: time.Now().UTC()}
+
+	// Activity that's still waiting to be written counts too
+	err := FlushActivity()
+	if err != nil {
+		log.Println(err)
+	}
+
+	guildData, err := GetGuild(guildId)
+	if err != nil {
+		return nil, err
+	}
+	export.Guild = guildData
+
+	cur, err := MongoClient.UsersCollection().Find(context.Background(), bson.D{{"guildId", guildId}})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(context.Background())
+
+	for cur.Next(context.Background()) {
+		var user UserData
+		err := cur.Decode(&user)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		export.Users = append(export.Users, ExportedUser{
+			UserId:         user.UserId,
+			LastActivity:   user.LastActivity,
+			ActivitySource: user.ActivitySource,
+			TrackedSince:   user.TrackedSince,
+			Immune:         user.Immune,
+			ImmuneUntil:    user.ImmuneUntil,
+			Warnings:       user.Warnings,
+			LastWarned:     user.LastWarned,
+		})
+	}
+	return export, nil
+}
+
+func (self *GuildExport) WriteJSON(writer io.Writer) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(self)
+}
+
+// Writes the users of the export as CSV, the guild's settings don't fit in there
+func (self *GuildExport) WriteCSV(writer io.Writer) error {
+	csvWriter := csv.NewWriter(writer)
+
+	err := csvWriter.Write(exportColumns)
+	if err != nil {
+		return err
+	}
+
+	for _, user := range self.Users {
+		err := csvWriter.Write([]string{
+			user.UserId,
+			user.LastActivity.UTC().Format(time.RFC3339),
+			user.ActivitySource,
+			user.TrackedSince.UTC().Format(time.RFC3339),
+			strconv.FormatBool(user.Immune),
+			user.ImmuneUntil.UTC().Format(time.RFC3339),
+			strconv.FormatInt(user.Warnings, 10),
+			user.LastWarned.UTC().Format(time.RFC3339),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+func ReadExportJSON(reader io.Reader) (*GuildExport, error) {
+	var export GuildExport
+
+	// Settings that didn't exist yet when the file was made keep their defaults
+	defaults := createGuild("")
+	export.Guild = &defaults
+
+	err := json.NewDecoder(reader).Decode(&export)
+	if err != nil {
+		return nil, err
+	}
+
+	if export.SchemaVersion > SchemaVersion() {
+		return nil, errors.New(fmt.Sprint("The file is from a newer version of the bot (schema ", export.SchemaVersion, ")"))
+	}
+
+	err = export.checkUsers(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+// Reads users from a CSV file with a header row, the columns can be in any order
+// Besides RFC 3339 times can be unix seconds or plain dates, which makes it easy to bring data over from other bots
+// Plain dates are read in the given timezone
+func ReadExportCSV(reader io.Reader, location *time.Location) (*GuildExport, error) {
+	export := &GuildExport{Columns: make(map[string]bool)}
+
+	rows, err := csv.NewReader(reader).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rows) == 0 {
+		return nil, errors.New("The file is empty")
+	}
+
+	columns := make(map[string]int)
+	for i, name := range rows[0] {
+		columns[strings.TrimSpace(name)] = i
+		export.Columns[strings.TrimSpace(name)] = true
+	}
+
+	if _, ok := columns["userId"]; !ok {
+		return nil, errors.New("The file needs a userId column")
+	}
+	if _, ok := columns["lastActivity"]; !ok {
+		return nil, errors.New("The file needs a lastActivity column")
+	}
+
+	for line, row := range rows[1:] {
+		value := func(column string) string {
+			i, ok := columns[column]
+			if !ok || i >= len(row) {
+				return ""
+			}
+			return strings.TrimSpace(row[i])
+		}
+
+		// Line numbers start at 1 and the header is the first line
+		lineError := func(err error) error {
+			return errors.New(fmt.Sprint("Line ", line+2, ": ", err.Error()))
+		}
+
+		user := ExportedUser{UserId: value("userId"), ActivitySource: value("activitySource")}
+		if user.UserId == "" {
+			return nil, lineError(errors.New("missing userId"))
+		}
+
+		user.LastActivity, err = parseExportTime(value("lastActivity"), location)
+		if err != nil {
+			return nil, lineError(err)
+		}
+
+		user.TrackedSince, err = parseExportTime(value("trackedSince"), location)
+		if err != nil {
+			return nil, lineError(err)
+		}
+
+		user.ImmuneUntil, err = parseExportTime(value("immuneUntil"), location)
+		if err != nil {
+			return nil, lineError(err)
+		}
+
+		user.LastWarned, err = parseExportTime(value("lastWarned"), location)
+		if err != nil {
+			return nil, lineError(err)
+		}
+
+		if immune := value("immune"); immune != "" {
+			user.Immune, err = strconv.ParseBool(immune)
+			if err != nil {
+				return nil, lineError(err)
+			}
+		}
+
+		if warnings := value("warnings"); warnings != "" {
+			user.Warnings, err = strconv.ParseInt(warnings, 0, 64)
+			if err != nil {
+				return nil, lineError(err)
+			}
+		}
+
+		export.Users = append(export.Users, user)
+	}
+
+	err = export.checkUsers(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return export, nil
+}
+
+func parseExportTime(value string, location *time.Location) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	stamp, err := time.Parse(time.RFC3339, value)
+	if err == nil {
+		return stamp.UTC(), nil
+	}
+
+	stamp, err = time.ParseInLocation("2006-01-02", value, location)
+	if err == nil {
+		return stamp.UTC(), nil
+	}
+
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err == nil {
+		return time.Unix(seconds, 0).UTC(), nil
+	}
+	return time.Time{}, errors.New(fmt.Sprint("Invalid time ", value))
+}
+
+// Stores the users of an export for a guild, only the given members are imported
+// Unless replace is set users keep whichever activity is more recent, their own or the imported one, and the fields the file doesn't have
+// Returns how many users were imported
+func ImportUsers(guildId string, export *GuildExport, members map[string]bool, replace bool) (int, error) {
+	var models []mongo.WriteModel
+
+	// Activity that's still waiting to be written counts too
+	err := FlushActivity()
+	if err != nil {
+		log.Println(err)
+	}
+
+	existing := make(map[string]UserData)
+	cur, err := MongoClient.UsersCollection().Find(context.Background(), bson.D{{"guildId", guildId}})
+	if err != nil {
+		return 0, err
+	}
+
+	for cur.Next(context.Background()) {
+		var user UserData
+		err := cur.Decode(&user)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		existing[user.UserId] = user
+	}
+	cur.Close(context.Background())
+
+	for _, imported := range export.Users {
+		if !members[imported.UserId] {
+			continue
+		}
+
+		// Without any activity the user would be kicked with the next run
+		if imported.LastActivity.IsZero() {
+			continue
+		}
+
+		user := UserData{
+			GuildId:        guildId,
+			UserId:         imported.UserId,
+			LastActivity:   imported.LastActivity,
+			ActivitySource: imported.ActivitySource,
+			TrackedSince:   imported.TrackedSince,
+			Immune:         imported.Immune,
+			ImmuneUntil:    imported.ImmuneUntil,
+			Warnings:       imported.Warnings,
+			LastWarned:     imported.LastWarned,
+		}
+
+		if user.ActivitySource == "" {
+			user.ActivitySource = SourceImport
+		}
+		if user.TrackedSince.IsZero() {
+			user.TrackedSince = time.Now().UTC()
+		}
+
+		// Newer activity the bot saw itself wins when merging
+		current, ok := existing[imported.UserId]
+		if ok && !replace && current.LastActivity.After(user.LastActivity) {
+			user.LastActivity = current.LastActivity
+			user.ActivitySource = current.ActivitySource
+			user.Warnings = current.Warnings
+			user.LastWarned = current.LastWarned
+			user.ScoreWarned = current.ScoreWarned
+		}
+
+		// Fields the file doesn't have are kept, so a file from another bot doesn't take away immunity given here
+		if ok && !replace {
+			if !export.HasField("immune") {
+				user.Immune = current.Immune
+			}
+			if !export.HasField("immuneUntil") {
+				user.ImmuneUntil = current.ImmuneUntil
+			}
+			if !export.HasField("trackedSince") {
+				user.TrackedSince = current.TrackedSince
+			}
+		}
+
+		filter := bson.D{{"guildId", guildId}, {"userId", user.UserId}}
+		models = append(models, mongo.NewReplaceOneModel().SetFilter(filter).SetReplacement(user).SetUpsert(true))
+	}
+
+	if len(models) == 0 {
+		return 0, nil
+	}
+
+	_, err = MongoClient.UsersCollection().BulkWrite(context.Background(), models, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		return 0, err
+	}
+	return len(models), nil
+}
+
+// Takes over the settings of an export, the parts that only make sense for the running bot are kept
+func (self *GuildData) ImportSettings(imported *GuildData) error {
+	filter := bson.D{{"guildId", self.GuildId}}
+
+	settings := *imported
+	settings.GuildId = self.GuildId
+	settings.LastUpdated = self.LastUpdated
+	settings.LastBatch = self.LastBatch
+	settings.AwaitingConfirm = self.AwaitingConfirm
+	*self = settings
+
+	// Update database
+	_, err := MongoClient.ServersCollection().ReplaceOne(context.Background(), filter, *self)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func handleExport(session *discord.Session, data *discord.MessageCreate, guild *discord.Guild, args []string) {
+	export, err := ExportGuild(guild.ID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	file := new(bytes.Buffer)
+	name := fmt.Sprint("yeetbot-", guild.ID, ".json")
+	contentType := "application/json"
+
+	if len(args) > 0 && strings.ToLower(args[0]) == "csv" {
+		name = fmt.Sprint("yeetbot-", guild.ID, ".csv")
+		contentType = "text/csv"
+		err = export.WriteCSV(file)
+	} else {
+		err = export.WriteJSON(file)
+	}
+
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	_, err = session.ChannelMessageSendComplex(data.ChannelID, &discord.MessageSend{
+		Content: fmt.Sprint("**Exported ", len(export.Users), " users**"),
+		Files: []*discord.File{{
+			Name:        name,
+			ContentType: contentType,
+			Reader:      file,
+		}},
+	})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func handleImport(session *discord.Session, data *discord.MessageCreate, guild *discord.Guild, guildData *GuildData, args []string) {
+	if len(data.Attachments) != 1 {
+		session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**Attach a file made with ", cmdTag, " export, or a CSV file with userId and lastActivity columns**"))
+		return
+	}
+
+	attachment := data.Attachments[0]
+	if attachment.Size > maxImportSize {
+		session.ChannelMessageSend(data.ChannelID, "**The file is too big**")
+		return
+	}
+
+	replace := len(args) > 0 && strings.ToLower(args[0]) == "replace"
+
+	response, err := importClient.Get(attachment.URL)
+	if err != nil {
+		log.Println(err)
+		session.ChannelMessageSend(data.ChannelID, "**Could not download the file**")
+		return
+	}
+	defer response.Body.Close()
+
+	body := io.LimitReader(response.Body, maxImportSize)
+
+	var export *GuildExport
+	if strings.HasSuffix(strings.ToLower(attachment.Filename), ".csv") {
+		export, err = ReadExportCSV(body, guildData.Location())
+	} else {
+		export, err = ReadExportJSON(body)
+	}
+
+	if err != nil {
+		session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**Could not read the file: ", err.Error(), "**"))
+		return
+	}
+
+	session.ChannelMessageSend(data.ChannelID, "**Importing...**")
+
+	// Users who aren't on the server anymore would only be kicked again
+	members := make(map[string]bool)
+	for _, member := range getMemberList(session, guild) {
+		if member.User.ID != guild.OwnerID && member.User.ID != SelfId {
+			members[member.User.ID] = true
+		}
+	}
+
+	imported, err := ImportUsers(guild.ID, export, members, replace)
+	if err != nil {
+		log.Println(err)
+		session.ChannelMessageSend(data.ChannelID, fmt.Sprint("**", err.Error(), "**"))
+		return
+	}
+
+	result := fmt.Sprint("**Imported ", imported, " of ", len(export.Users), " users, the rest aren't on the server or have no activity**")
+
+	// Settings only come along when they were exported from this same server
+	if export.Guild != nil && export.Guild.GuildId == guild.ID {
+		err = guildData.ImportSettings(export.Guild)
+		if err != nil {
+			log.Println(err)
+		} else {
+			result += "\n**The server settings were restored as well**"
+		}
+	}
+	session.ChannelMessageSend(data.ChannelID, result)
+}
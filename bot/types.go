@@ -2,19 +2,42 @@ package bot
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 var SelfId string
 
 type ConfigData struct {
-	Token            string `json:"token"`
-	ConnectionString string `json:"connectionString"`
+	Token            string   `json:"token"`
+	ConnectionString string   `json:"connectionString"`
+	PresenceIntent   bool     `json:"presenceIntent"`
+	Maintenance      bool     `json:"maintenance"`
+	Admins           []string `json:"admins"`
+}
+
+// Reads the config from a json file
+func LoadConfig(path string) (ConfigData, error) {
+	var config ConfigData
+
+	cfgstr, err := ioutil.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+
+	// Marshal config json to config struct
+	err = json.Unmarshal(cfgstr, &config)
+	if err != nil {
+		return config, err
+	}
+	return config, nil
 }
 
 func createGuild(guildId string) GuildData {
@@ -24,12 +47,29 @@ func createGuild(guildId string) GuildData {
 	guildData.GuildId = guildId
 	guildData.MaxDayInactivity = 30
 	guildData.FirstWarnOffset = -1
+	guildData.RestoreRoles = false
+	guildData.RestoreWindow = 14
+	guildData.MaxKicksPerRun = 100
+	guildData.MaxKickPercent = 20
+	guildData.ScorePolicy = false
+	guildData.ScoreThreshold = 5
+	guildData.ScoreWindow = 60
+	guildData.MinVoiceMinutes = 5
+	guildData.RunTime = -1
 	return guildData
 }
 
+// Creates a guild if it isn't in the database yet, guilds that already exist are left alone
 func CreateGuild(guildId string) error {
 	data := createGuild(guildId)
-	_, err := MongoClient.ServersCollection().InsertOne(context.Background(), data)
+	filter := bson.D{{"guildId", guildId}}
+
+	fields, err := documentFields(data, "guildId")
+	if err != nil {
+		return err
+	}
+
+	_, err = MongoClient.ServersCollection().UpdateOne(context.Background(), filter, bson.D{{"$setOnInsert", fields}}, options.Update().SetUpsert(true))
 	if err != nil {
 		return err
 	}
@@ -73,6 +113,44 @@ type GuildData struct {
 	MaxDayInactivity int64     `bson:"dayInactivity"`
 	LastUpdated      time.Time `bson:"lastUpdated"`
 	FirstWarnOffset  int64     `bson:"warnOffset"`
+	LastBatch        string    `bson:"lastBatch"`
+	RestoreRoles     bool      `bson:"restoreRoles"`
+	RestoreWindow    int64     `bson:"restoreWindow"`
+	MaxKicksPerRun   int64     `bson:"maxKicks"`
+	MaxKickPercent   int64     `bson:"maxKickPercent"`
+	AwaitingConfirm  bool      `bson:"awaitingConfirm"`
+	LogChannel       string    `bson:"logChannel"`
+	ReviewKicks      bool      `bson:"reviewKicks"`
+	ScorePolicy      bool      `bson:"scorePolicy"`
+	ScoreThreshold   float64   `bson:"scoreThreshold"`
+	ScoreWindow      int64     `bson:"scoreWindow"`
+	ScoreSince       time.Time `bson:"scoreSince"`
+	MinVoiceMinutes  int64     `bson:"minVoiceMinutes"`
+	ChannelAllowList bool      `bson:"channelAllowList"`
+
+	// Rules messages have to pass to count as activity
+	MinMessageLength   int64 `bson:"minMessageLength"`
+	IgnoreEmojiOnly    bool  `bson:"ignoreEmojiOnly"`
+	IgnoreRepeatedWord bool  `bson:"ignoreRepeatedWord"`
+	IgnoreDuplicates   bool  `bson:"ignoreDuplicates"`
+
+	// Channels and categories excluded from activity, or the only ones that count when ChannelAllowList is set
+	IgnoredChannels   []string `bson:"ignoredChannels"`
+	IgnoredCategories []string `bson:"ignoredCategories"`
+
+	// Whether activity in threads that have been archived is ignored
+	IgnoreArchivedThreads bool `bson:"ignoreArchivedThreads"`
+
+	// The timezone days are counted in and the minute of the day kicks happen at, -1 means any time
+	Timezone string `bson:"timezone"`
+	RunTime  int64  `bson:"runTime"`
+
+	// Whether automated warnings and kicks are stopped, until the given time unless it's zero
+	Paused      bool      `bson:"paused"`
+	PausedUntil time.Time `bson:"pausedUntil"`
+
+	// How much each source of activity counts for, nil means the defaults are used
+	ActivityWeights map[string]float64 `bson:"activityWeights"`
 }
 
 func (self *GuildData) UpdateWarnOffset(offset int64) error {
@@ -160,6 +238,187 @@ func (self *GuildData) UpdateLastUpdated(updateTime time.Time) error {
 
 	self.LastUpdated = updateTime
 
+	// Update database
+	_, err := MongoClient.ServersCollection().UpdateOne(context.Background(), filter, bson.D{{"$set", bson.D{{"lastUpdated", updateTime}}}})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (self *GuildData) SetLastBatch(batchId string) error {
+	filter := bson.D{{"guildId", self.GuildId}}
+
+	self.LastBatch = batchId
+
+	// Only set the batch, the guild is a copy from before the run and replacing it would undo changes made during it
+	_, err := MongoClient.ServersCollection().UpdateOne(context.Background(), filter, bson.D{{"$set", bson.D{{"lastBatch", batchId}}}})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (self *GuildData) SetRestoreRoles(restore bool) error {
+	filter := bson.D{{"guildId", self.GuildId}}
+
+	self.RestoreRoles = restore
+
+	// Guilds from before role restoring existed have no window yet
+	if self.RestoreWindow < 1 {
+		self.RestoreWindow = 14
+	}
+
+	// Update database
+	_, err := MongoClient.ServersCollection().ReplaceOne(context.Background(), filter, *self)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (self *GuildData) UpdateRestoreWindow(days int64) error {
+	filter := bson.D{{"guildId", self.GuildId}}
+
+	// Restoring needs at least a day to be of any use
+	if days < 1 {
+		days = 1
+	}
+
+	// Over a year is a bit long
+	if days > 365 {
+		days = 365
+	}
+
+	self.RestoreWindow = days
+
+	// Update database
+	_, err := MongoClient.ServersCollection().ReplaceOne(context.Background(), filter, *self)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Checks whether kicking the given amount of users would go over the guild's safety cap
+// A limit of 0 means that there is no limit
+func (self *GuildData) KickLimitExceeded(kicks, members int) bool {
+	if self.MaxKicksPerRun > 0 && int64(kicks) > self.MaxKicksPerRun {
+		return true
+	}
+
+	if self.MaxKickPercent > 0 && members > 0 && int64(kicks)*100 > self.MaxKickPercent*int64(members) {
+		return true
+	}
+	return false
+}
+
+func (self *GuildData) UpdateKickLimit(kicks int64) error {
+	filter := bson.D{{"guildId", self.GuildId}}
+
+	// 0 disables the limit
+	if kicks < 0 {
+		kicks = 0
+	}
+
+	self.MaxKicksPerRun = kicks
+
+	// Update database
+	_, err := MongoClient.ServersCollection().ReplaceOne(context.Background(), filter, *self)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (self *GuildData) UpdateKickPercent(percent int64) error {
+	filter := bson.D{{"guildId", self.GuildId}}
+
+	// 0 disables the limit
+	if percent < 0 {
+		percent = 0
+	}
+
+	if percent > 100 {
+		percent = 100
+	}
+
+	self.MaxKickPercent = percent
+
+	// Update database
+	_, err := MongoClient.ServersCollection().ReplaceOne(context.Background(), filter, *self)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (self *GuildData) SetAwaitingConfirm(awaiting bool) error {
+	filter := bson.D{{"guildId", self.GuildId}}
+
+	self.AwaitingConfirm = awaiting
+
+	// Update database
+	_, err := MongoClient.ServersCollection().UpdateOne(context.Background(), filter, bson.D{{"$set", bson.D{{"awaitingConfirm", awaiting}}}})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (self *GuildData) SetLogChannel(channelId string) error {
+	filter := bson.D{{"guildId", self.GuildId}}
+
+	self.LogChannel = channelId
+
+	// Update database
+	_, err := MongoClient.ServersCollection().ReplaceOne(context.Background(), filter, *self)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (self *GuildData) SetReviewKicks(review bool) error {
+	filter := bson.D{{"guildId", self.GuildId}}
+
+	self.ReviewKicks = review
+
+	// Update database
+	_, err := MongoClient.ServersCollection().ReplaceOne(context.Background(), filter, *self)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Gets how much a source of activity counts for, 0 means it doesn't count at all
+func (self *GuildData) ActivityWeight(source string) float64 {
+
+	// Guilds that never changed their sources use the defaults
+	if self.ActivityWeights == nil {
+		return defaultActivityWeights[source]
+	}
+	return self.ActivityWeights[source]
+}
+
+func (self *GuildData) SetActivityWeight(source string, weight float64) error {
+	filter := bson.D{{"guildId", self.GuildId}}
+
+	if weight < 0 {
+		return errors.New("Weight can't be negative")
+	}
+
+	// Start from whatever the guild is using right now
+	if self.ActivityWeights == nil {
+		self.ActivityWeights = make(map[string]float64)
+		for _, activitySource := range ActivitySources {
+			self.ActivityWeights[activitySource] = self.ActivityWeight(activitySource)
+		}
+	}
+
+	self.ActivityWeights[source] = weight
+
 	// Update database
 	_, err := MongoClient.ServersCollection().ReplaceOne(context.Background(), filter, *self)
 	if err != nil {
@@ -168,6 +427,300 @@ func (self *GuildData) UpdateLastUpdated(updateTime time.Time) error {
 	return nil
 }
 
+// Checks whether the score policy is on and has been on for long enough to have a full window of scores
+func (self *GuildData) ScorePolicyActive(now time.Time) bool {
+	return self.ScorePolicy && !self.ScoreSince.AddDate(0, 0, int(self.ScoreWindow)).After(now)
+}
+
+func (self *GuildData) SetScorePolicy(enabled bool) error {
+	filter := bson.D{{"guildId", self.GuildId}}
+
+	// The window starts over whenever the policy gets turned on
+	if enabled && !self.ScorePolicy {
+		self.ScoreSince = time.Now().UTC()
+	}
+
+	self.ScorePolicy = enabled
+
+	// Guilds from before the score policy existed have no window yet
+	if self.ScoreWindow < 1 {
+		self.ScoreWindow = 60
+	}
+
+	// Update database
+	_, err := MongoClient.ServersCollection().ReplaceOne(context.Background(), filter, *self)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (self *GuildData) UpdateScorePolicy(threshold float64, days int64) error {
+	filter := bson.D{{"guildId", self.GuildId}}
+
+	if threshold < 0 {
+		return errors.New("Threshold can't be negative")
+	}
+
+	// A window shorter than the usual minimum timeout isn't worth much
+	if days < 5 || days > maxScoreWindow {
+		return errors.New("Days have to be between 5 and 365")
+	}
+
+	self.ScoreThreshold = threshold
+	self.ScoreWindow = days
+
+	// Update database
+	_, err := MongoClient.ServersCollection().ReplaceOne(context.Background(), filter, *self)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (self *GuildData) UpdateMinVoiceMinutes(minutes int64) error {
+	filter := bson.D{{"guildId", self.GuildId}}
+
+	if minutes < 0 {
+		minutes = 0
+	}
+
+	// Nobody is in voice for more than a day
+	if minutes > 24*60 {
+		minutes = 24 * 60
+	}
+
+	self.MinVoiceMinutes = minutes
+
+	// Update database
+	_, err := MongoClient.ServersCollection().ReplaceOne(context.Background(), filter, *self)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Checks whether activity in a channel counts, the category can be empty
+func (self *GuildData) CountsChannel(channelId, categoryId string) bool {
+	listed := containsString(self.IgnoredChannels, channelId) ||
+		(categoryId != "" && containsString(self.IgnoredCategories, categoryId))
+
+	// An empty allow list would make everyone inactive, so it counts every channel instead
+	if self.ChannelAllowList {
+		return listed || (len(self.IgnoredChannels) == 0 && len(self.IgnoredCategories) == 0)
+	}
+	return !listed
+}
+
+// Adds a channel or category to the list, or removes it if it's already there
+// Returns whether the channel is in the list now
+func (self *GuildData) ToggleChannel(channelId string, category bool) (bool, error) {
+	filter := bson.D{{"guildId", self.GuildId}}
+
+	list := &self.IgnoredChannels
+	if category {
+		list = &self.IgnoredCategories
+	}
+
+	listed := !containsString(*list, channelId)
+	if listed {
+		*list = append(*list, channelId)
+	} else {
+		*list = removeString(*list, channelId)
+	}
+
+	// Update database
+	_, err := MongoClient.ServersCollection().ReplaceOne(context.Background(), filter, *self)
+	if err != nil {
+		return false, err
+	}
+	return listed, nil
+}
+
+func (self *GuildData) SetChannelAllowList(allow bool) error {
+	filter := bson.D{{"guildId", self.GuildId}}
+
+	self.ChannelAllowList = allow
+
+	// Update database
+	_, err := MongoClient.ServersCollection().ReplaceOne(context.Background(), filter, *self)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (self *GuildData) UpdateMinMessageLength(length int64) error {
+	filter := bson.D{{"guildId", self.GuildId}}
+
+	if length < 0 {
+		length = 0
+	}
+
+	// Discord doesn't allow messages longer than this anyways
+	if length > 2000 {
+		length = 2000
+	}
+
+	self.MinMessageLength = length
+
+	// Update database
+	_, err := MongoClient.ServersCollection().ReplaceOne(context.Background(), filter, *self)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (self *GuildData) SetMessageRule(rule string, enabled bool) error {
+	filter := bson.D{{"guildId", self.GuildId}}
+
+	switch rule {
+	case "emoji":
+		self.IgnoreEmojiOnly = enabled
+	case "repeat":
+		self.IgnoreRepeatedWord = enabled
+	case "duplicate":
+		self.IgnoreDuplicates = enabled
+	default:
+		return errors.New(fmt.Sprint("Unknown message rule ", rule))
+	}
+
+	// Update database
+	_, err := MongoClient.ServersCollection().ReplaceOne(context.Background(), filter, *self)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (self *GuildData) SetIgnoreArchivedThreads(value bool) error {
+	filter := bson.D{{"guildId", self.GuildId}}
+
+	self.IgnoreArchivedThreads = value
+
+	// Update database
+	_, err := MongoClient.ServersCollection().ReplaceOne(context.Background(), filter, *self)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Checks whether automated warnings and kicks are stopped for the guild
+func (self *GuildData) IsPaused(now time.Time) bool {
+	return self.Paused && (self.PausedUntil.IsZero() || self.PausedUntil.After(now))
+}
+
+// Stops automated warnings and kicks until the given time, a zero time pauses until resumed
+func (self *GuildData) SetPause(paused bool, until time.Time) error {
+	filter := bson.D{{"guildId", self.GuildId}}
+
+	self.Paused = paused
+	self.PausedUntil = until
+
+	// Update database
+	_, err := MongoClient.ServersCollection().ReplaceOne(context.Background(), filter, *self)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Gets the days of inactivity users get warned at, the first warning and the one on the last day
+func (self *GuildData) WarningDays() (int64, int64) {
+	halfwayMark := self.MaxDayInactivity / 2
+	lastDay := self.MaxDayInactivity - 1
+
+	// If the admin has specified a day offset for the warning use that instead
+	if self.FirstWarnOffset >= 5 {
+		halfwayMark = self.FirstWarnOffset
+	}
+	return halfwayMark, lastDay
+}
+
+// Gets the timezone of the guild, guilds without one use UTC
+func (self *GuildData) Location() *time.Location {
+	location, err := time.LoadLocation(self.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
+
+// Gets the number of days since the unix epoch, in the guild's timezone
+func (self *GuildData) Day(stamp time.Time) int64 {
+	year, month, day := stamp.In(self.Location()).Date()
+	return dayOf(time.Date(year, month, day, 0, 0, 0, 0, time.UTC))
+}
+
+// Checks whether the guild's run time has come for the day
+func (self *GuildData) IsRunTime(now time.Time) bool {
+	if self.RunTime < 0 {
+		return true
+	}
+
+	local := now.In(self.Location())
+	return int64(local.Hour()*60+local.Minute()) >= self.RunTime
+}
+
+// Gets the next time the guild's run time comes around, guilds without one can run right away
+func (self *GuildData) NextScheduledRun(now time.Time) time.Time {
+	if self.RunTime < 0 {
+		return now
+	}
+
+	local := now.In(self.Location())
+	next := time.Date(local.Year(), local.Month(), local.Day(), int(self.RunTime/60), int(self.RunTime%60), 0, 0, local.Location())
+	if !next.After(now) {
+		next = time.Date(local.Year(), local.Month(), local.Day()+1, int(self.RunTime/60), int(self.RunTime%60), 0, 0, local.Location())
+	}
+	return next
+}
+
+// Sets the minute of the day kicks happen at and the timezone days are counted in
+func (self *GuildData) SetSchedule(runTime int64, timezone string) error {
+	filter := bson.D{{"guildId", self.GuildId}}
+
+	_, err := time.LoadLocation(timezone)
+	if err != nil {
+		return errors.New(fmt.Sprint("Unknown timezone ", timezone))
+	}
+
+	if runTime < -1 || runTime >= 24*60 {
+		return errors.New("Run time has to be between 00:00 and 23:59")
+	}
+
+	self.RunTime = runTime
+	self.Timezone = timezone
+
+	// Update database
+	_, err = MongoClient.ServersCollection().ReplaceOne(context.Background(), filter, *self)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func removeString(list []string, value string) []string {
+	result := make([]string, 0, len(list))
+	for _, item := range list {
+		if item != value {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
 func (self *GuildData) DeleteUser(userId string) error {
 	return DeleteUser(self.GuildId, userId)
 }
@@ -176,22 +729,64 @@ func (self *GuildData) GetUser(userId string) (*UserData, error) {
 	return GetUser(self.GuildId, userId)
 }
 
-func createUser(guildId, userId string, lastAcitivity time.Time) UserData {
+// Where a user's last activity came from
+const (
+	SourceMessage   = "message"
+	SourceVoice     = "voice"
+	SourceReaction  = "reaction"
+	SourceVoiceTime = "voicetime"
+	SourceThread    = "thread"
+	SourceSlash     = "slash"
+	SourceStage     = "stage"
+	SourcePresence  = "presence"
+	SourceJoin      = "join"
+	SourceForceAdd  = "forceadd"
+	SourceBackfill  = "backfill"
+	SourceManual    = "manual"
+	SourceImport    = "import"
+)
+
+func createUser(guildId, userId string, lastAcitivity time.Time, source string) UserData {
 	var userData UserData
 	userData.GuildId = guildId
 	userData.UserId = userId
 	userData.LastActivity = lastAcitivity
+	userData.ActivitySource = source
+	userData.TrackedSince = time.Now().UTC()
 	userData.Immune = false
 	return userData
 }
 
-func CreateUser(guildId, userId string, lastMessage time.Time) error {
-	data := createUser(guildId, userId, lastMessage)
-	_, err := MongoClient.UsersCollection().InsertOne(context.Background(), data)
+// Creates a user if they aren't in the database yet, users that already exist are left alone
+// Returns whether the user got created
+func CreateUser(guildId, userId string, lastMessage time.Time, source string) (bool, error) {
+	data := createUser(guildId, userId, lastMessage, source)
+	filter := bson.D{{"guildId", guildId}, {"userId", userId}}
+
+	fields, err := documentFields(data, "guildId", "userId")
 	if err != nil {
-		return err
+		return false, err
 	}
-	return nil
+
+	result, err := MongoClient.UsersCollection().UpdateOne(context.Background(), filter, bson.D{{"$setOnInsert", fields}}, options.Update().SetUpsert(true))
+	if err != nil {
+		return false, err
+	}
+	return result.UpsertedCount > 0, nil
+}
+
+// Tracks a user from the time they joined
+// Users that rejoin before their old data got deleted start over too, only their immunity is kept
+func JoinUser(guildId, userId string, joined time.Time) error {
+	data := createUser(guildId, userId, joined, SourceJoin)
+	return data.upsert(bson.D{
+		{"lastActivity", data.LastActivity},
+		{"activitySource", data.ActivitySource},
+		{"trackedSince", data.TrackedSince},
+		{"lastWarned", data.LastWarned},
+		{"warnings", data.Warnings},
+		{"scoreWarned", data.ScoreWarned},
+	})
 }
 
 func DeleteUser(guildId, userId string) error {
@@ -233,34 +828,122 @@ func GetUser(guildId, userId string) (*UserData, error) {
 }
 
 type UserData struct {
-	GuildId      string    `bson:"guildId"`
-	UserId       string    `bson:"userId"`
-	LastActivity time.Time `bson:"lastActivity`
-	Immune       bool      `bson:"immune"`
+	GuildId        string    `bson:"guildId"`
+	UserId         string    `bson:"userId"`
+	LastActivity   time.Time `bson:"lastActivity"`
+	ActivitySource string    `bson:"activitySource"`
+	TrackedSince   time.Time `bson:"trackedSince"`
+	Immune         bool      `bson:"immune"`
+	ImmuneUntil    time.Time `bson:"immuneUntil"`
+	LastWarned     time.Time `bson:"lastWarned"`
+	Warnings       int64     `bson:"warnings"`
+	ScoreWarned    time.Time `bson:"scoreWarned"`
 }
 
-func (self *UserData) UpdateActivity(time time.Time) error {
-	filter := bson.D{{"guildId", self.GuildId}, {"userId", self.UserId}}
+// Checks whether the user is immune, either permanently or temporarily
+func (self *UserData) IsImmune(now time.Time) bool {
+	return self.Immune || self.ImmuneUntil.After(now)
+}
 
+func (self *UserData) UpdateActivity(time time.Time, source string) error {
 	self.LastActivity = time
+	self.ActivitySource = source
+	self.Warnings = 0
 
 	// Update database
-	_, err := MongoClient.UsersCollection().ReplaceOne(context.Background(), filter, *self)
+	return self.upsert(bson.D{{"lastActivity", time}, {"activitySource", source}, {"warnings", 0}})
+}
+
+func (self *UserData) UpdateImmunity(immunity bool) error {
+	self.Immune = immunity
+
+	// Update database
+	return self.upsert(bson.D{{"immune", immunity}})
+}
+
+func (self *UserData) UpdateImmuneUntil(until time.Time) error {
+	self.ImmuneUntil = until
+
+	// Update database
+	return self.upsert(bson.D{{"immuneUntil", until}})
+}
+
+// Remembers when the user was last warned and counts the warning, the count starts over when they become active again
+func (self *UserData) UpdateLastWarned(warned time.Time) error {
+	filter := bson.D{{"guildId", self.GuildId}, {"userId", self.UserId}}
+
+	self.LastWarned = warned
+	self.Warnings++
+
+	// Update database
+	update := bson.D{{"$set", bson.D{{"lastWarned", warned}}}, {"$inc", bson.D{{"warnings", 1}}}}
+	_, err := MongoClient.UsersCollection().UpdateOne(context.Background(), filter, update)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (self *UserData) UpdateImmunity(immunity bool) error {
+// Remembers when the user was last warned about their activity score
+func (self *UserData) UpdateScoreWarned(warned time.Time) error {
 	filter := bson.D{{"guildId", self.GuildId}, {"userId", self.UserId}}
 
-	self.Immune = immunity
+	self.ScoreWarned = warned
 
 	// Update database
-	_, err := MongoClient.UsersCollection().ReplaceOne(context.Background(), filter, *self)
+	_, err := MongoClient.UsersCollection().UpdateOne(context.Background(), filter, bson.D{{"$set", bson.D{{"scoreWarned", warned}}}})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Sets the given fields of the user without touching the others
+// Users that got deleted in the meantime are created again from the rest of their data
+func (self *UserData) upsert(set bson.D) error {
+	filter := bson.D{{"guildId", self.GuildId}, {"userId", self.UserId}}
+
+	skip := []string{"guildId", "userId"}
+	for _, field := range set {
+		skip = append(skip, field.Key)
+	}
+
+	fields, err := documentFields(*self, skip...)
+	if err != nil {
+		return err
+	}
+
+	update := bson.D{{"$set", set}}
+	if len(fields) > 0 {
+		update = append(update, bson.E{"$setOnInsert", fields})
+	}
+
+	_, err = MongoClient.UsersCollection().UpdateOne(context.Background(), filter, update, options.Update().SetUpsert(true))
 	if err != nil {
 		return err
 	}
 	return nil
 }
+
+// Turns a document into its fields, leaving out the given ones
+func documentFields(document interface{}, skip ...string) (bson.D, error) {
+	var fields bson.D
+
+	raw, err := bson.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+
+	var all bson.D
+	err = bson.Unmarshal(raw, &all)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, field := range all {
+		if !containsString(skip, field.Key) {
+			fields = append(fields, field)
+		}
+	}
+	return fields, nil
+}
@@ -0,0 +1,206 @@
+package bot
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	discord "github.com/bwmarrin/discordgo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type voiceSession struct {
+	guildId   string
+	userId    string
+	channelId string
+	since     time.Time
+	counting  bool
+}
+
+// Everyone currently in a voice channel, by guild and user
+var voiceSessions = make(map[string]voiceSession)
+var voiceSessionsLock sync.Mutex
+
+func voiceSessionKey(guildId, userId string) string {
+	return guildId + ":" + userId
+}
+
+// AFK and deafened users are in voice, but not really there
+func countsAsVoiceTime(guild *discord.Guild, state *discord.VoiceState) bool {
+	return state.ChannelID != guild.AfkChannelID && !state.SelfDeaf && !state.Deaf
+}
+
+// Closes the user's current voice session and opens a new one for the state they're in now
+// The session only gets split when the user changes channel or starts or stops counting, so toggling mute or video doesn't cut it short
+// Returns how many minutes of the closed session counted as voice time and which channel it was in
+func updateVoiceSession(guild *discord.Guild, state *discord.VoiceState, stamp time.Time) (float64, string) {
+	key := voiceSessionKey(guild.ID, state.UserID)
+	counting := countsAsVoiceTime(guild, state)
+
+	voiceSessionsLock.Lock()
+	previous, ok := voiceSessions[key]
+	if ok && previous.channelId == state.ChannelID && previous.counting == counting {
+		voiceSessionsLock.Unlock()
+		return 0, ""
+	}
+	delete(voiceSessions, key)
+
+	if state.ChannelID != "" {
+		voiceSessions[key] = voiceSession{guild.ID, state.UserID, state.ChannelID, stamp, counting}
+	}
+	voiceSessionsLock.Unlock()
+
+	if !ok || !previous.counting {
+		return 0, ""
+	}
+	return recordVoiceTime(previous.guildId, previous.userId, previous.since, stamp), previous.channelId
+}
+
+// Opens sessions for everyone that's already in voice when the bot joins or reconnects to a guild
+// Sessions of users that left or moved while the bot was disconnected are dropped, when they did isn't known
+func openVoiceSessions(guild *discord.Guild) {
+	stamp := time.Now().UTC()
+
+	current := make(map[string]*discord.VoiceState)
+	for _, state := range guild.VoiceStates {
+		if state.ChannelID != "" {
+			current[voiceSessionKey(guild.ID, state.UserID)] = state
+		}
+	}
+
+	voiceSessionsLock.Lock()
+	defer voiceSessionsLock.Unlock()
+
+	for key, session := range voiceSessions {
+		if session.guildId != guild.ID {
+			continue
+		}
+
+		state, ok := current[key]
+		if !ok || state.ChannelID != session.channelId {
+			delete(voiceSessions, key)
+		}
+	}
+
+	for key, state := range current {
+		if _, ok := voiceSessions[key]; ok {
+			continue
+		}
+
+		voiceSessions[key] = voiceSession{guild.ID, state.UserID, state.ChannelID, stamp, countsAsVoiceTime(guild, state)}
+	}
+}
+
+// Forgets the voice session of a user that left the guild, their activity gets deleted anyways
+func discardVoiceSession(guildId, userId string) {
+	voiceSessionsLock.Lock()
+	delete(voiceSessions, voiceSessionKey(guildId, userId))
+	voiceSessionsLock.Unlock()
+}
+
+// Closes every open voice session so the time isn't lost when the bot shuts down
+func CloseVoiceSessions(session *discord.Session) {
+	stamp := time.Now().UTC()
+
+	voiceSessionsLock.Lock()
+	closed := make([]voiceSession, 0, len(voiceSessions))
+	for key, voice := range voiceSessions {
+		if voice.counting {
+			closed = append(closed, voice)
+		}
+		delete(voiceSessions, key)
+	}
+	voiceSessionsLock.Unlock()
+
+	for _, voice := range closed {
+		minutes := recordVoiceTime(voice.guildId, voice.userId, voice.since, stamp)
+
+		guild, err := GetDiscordGuild(session, voice.guildId)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		trackVoiceTime(session, guild, voice.channelId, voice.userId, stamp, minutes)
+	}
+}
+
+// Counts time spent in voice towards the user's activity, if it was long enough for the guild
+func trackVoiceTime(session *discord.Session, guild *discord.Guild, channelId, userId string, stamp time.Time, minutes float64) {
+	if minutes <= 0 {
+		return
+	}
+
+	guildData, err := getCachedGuild(guild.ID)
+	if err == nil && minutes >= float64(guildData.MinVoiceMinutes) {
+		trackActivity(session, guild, channelId, userId, stamp, SourceVoiceTime, minutes/60)
+	}
+}
+
+// Stores the time between two moments as voice minutes, split over the days it spans
+func recordVoiceTime(guildId, userId string, from, to time.Time) float64 {
+	unixDay := int64(24 * time.Hour.Seconds())
+	total := 0.0
+
+	for from.Before(to) {
+
+		// Stop at midnight, the rest goes to the next day
+		end := time.Unix((dayOf(from)+1)*unixDay, 0).UTC()
+		if to.Before(end) {
+			end = to
+		}
+
+		minutes := end.Sub(from).Minutes()
+		err := AddVoiceMinutes(guildId, userId, dayOf(from), minutes)
+		if err != nil {
+			log.Println(err)
+		}
+
+		total += minutes
+		from = end
+	}
+	return total
+}
+
+func AddVoiceMinutes(guildId, userId string, day int64, minutes float64) error {
+	filter := bson.D{{"guildId", guildId}, {"userId", userId}, {"day", day}}
+	update := bson.D{{"$inc", bson.D{{"voiceMinutes", minutes}}}}
+
+	_, err := MongoClient.ActivityCollection().UpdateOne(context.Background(), filter, update, options.Update().SetUpsert(true))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Gets the total voice minutes of every user in a guild from the given day onwards
+func GetVoiceMinutes(guildId string, fromDay int64) (map[string]float64, error) {
+	minutes := make(map[string]float64)
+
+	pipeline := mongo.Pipeline{
+		{{"$match", bson.D{{"guildId", guildId}, {"day", bson.D{{"$gte", fromDay}}}}}},
+		{{"$group", bson.D{{"_id", "$userId"}, {"minutes", bson.D{{"$sum", "$voiceMinutes"}}}}}},
+	}
+
+	cur, err := MongoClient.ActivityCollection().Aggregate(context.Background(), pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(context.Background())
+
+	for cur.Next(context.Background()) {
+		var result struct {
+			UserId  string  `bson:"_id"`
+			Minutes float64 `bson:"minutes"`
+		}
+
+		err := cur.Decode(&result)
+		if err != nil {
+			return nil, err
+		}
+		minutes[result.UserId] = result.Minutes
+	}
+	return minutes, nil
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	bot "github.com/Member1221/yeetbot/bot"
+)
+
+const usage = `Usage: yeetctl [-config config.json] (command) [arguments]
+
+Commands:
+  guilds                               Lists every guild with its tracked users and main settings
+  show (guild)                         Shows every setting of a guild
+  set (guild) (setting) (value)        Changes a setting of a guild, use the names shown by show
+  immune (guild) (user) (on/off/days)  Makes a user immune, permanently or for the given days
+  purge (guild) [-yes]                 Deletes everything stored about a guild, the bot has to be stopped
+  dry (guild) [owner]                  Shows who would be warned and kicked in the next run
+  migrate                              Brings the database up to date with this version
+`
+
+// Returned for commands that are missing arguments or don't exist
+var errUsage = errors.New("usage")
+
+func main() {
+	configPath := flag.String("config", "config.json", "path to the bot's config")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+	}
+	flag.Parse()
+
+	// Everything returns here, so the database connection gets closed before exiting
+	err := run(*configPath, flag.Args())
+	if err == errUsage {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(configPath string, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	// Load config json
+	config, err := bot.LoadConfig(configPath)
+	if err != nil {
+		return err
+	}
+
+	// Connect to database
+	err = bot.ConnectToDB(config.ConnectionString)
+	if err != nil {
+		return err
+	}
+	defer bot.MongoClient.Disconnect()
+
+	if args[0] == "migrate" {
+		err = bot.RunMigrations()
+		if err != nil {
+			return err
+		}
+		fmt.Println("Database is at schema version", bot.SchemaVersion())
+		return nil
+	}
+
+	// Reading a database of another version could misread or overwrite documents
+	version, err := bot.GetSchemaVersion()
+	if err != nil {
+		return err
+	}
+	if version != bot.SchemaVersion() {
+		return errors.New(fmt.Sprint("Database is at schema version ", version, " but this version expects ", bot.SchemaVersion(), ", start the bot or run yeetctl migrate first"))
+	}
+
+	switch args[0] {
+	case "guilds":
+		return listGuilds()
+	case "show":
+		return needArgs(args, 2, func() error { return showGuild(args[1]) })
+	case "set":
+		return needArgs(args, 4, func() error { return setField(args[1], args[2], strings.Join(args[3:], " ")) })
+	case "immune":
+		return needArgs(args, 4, func() error { return setImmunity(args[1], args[2], args[3]) })
+	case "purge":
+		return needArgs(args, 2, func() error { return purgeGuild(args[1], len(args) > 2 && args[2] == "-yes") })
+	case "dry":
+		return needArgs(args, 2, func() error {
+			owner := ""
+			if len(args) > 2 {
+				owner = args[2]
+			}
+			return dryRun(args[1], owner)
+		})
+	}
+	return errUsage
+}
+
+// Runs a command if it got enough arguments, the command name counts as one
+func needArgs(args []string, count int, command func() error) error {
+	if len(args) < count {
+		return errUsage
+	}
+	return command()
+}
+
+func listGuilds() error {
+	guilds, err := bot.ListGuilds()
+	if err != nil {
+		return err
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "GUILD\tUSERS\tDAYS\tLAST RUN\tREVIEW\tPAUSED\tSCHEDULE")
+
+	for _, guild := range guilds {
+		users, err := bot.CountUsers(guild.GuildId)
+		if err != nil {
+			return err
+		}
+
+		schedule := "any time"
+		if guild.RunTime >= 0 {
+			schedule = fmt.Sprintf("%02d:%02d", guild.RunTime/60, guild.RunTime%60)
+		}
+		schedule += " " + guild.Location().String()
+
+		fmt.Fprint(writer, guild.GuildId, "\t", users, "\t", guild.MaxDayInactivity, "\t", guild.LastUpdated.Format("2006-01-02 15:04"), "\t",
+			guild.ReviewKicks, "\t", guild.IsPaused(time.Now()), "\t", schedule, "\n")
+	}
+	return writer.Flush()
+}
+
+func showGuild(guildId string) error {
+	guild, err := bot.GetGuild(guildId)
+	if err != nil {
+		return err
+	}
+
+	settings, err := bot.GuildSettings(guild)
+	if err != nil {
+		return err
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, setting := range settings {
+		fmt.Fprint(writer, setting.Key, "\t", setting.Value, "\n")
+	}
+	return writer.Flush()
+}
+
+func setField(guildId, name, value string) error {
+	guild, err := bot.GetGuild(guildId)
+	if err != nil {
+		return err
+	}
+
+	err = guild.SetField(name, value)
+	if err != nil {
+		return err
+	}
+
+	// Some settings get clamped to their limits, so show what was really stored
+	settings, err := bot.GuildSettings(guild)
+	if err != nil {
+		return err
+	}
+
+	for _, setting := range settings {
+		if setting.Key == name {
+			fmt.Println("Set", name, "of", guildId, "to", setting.Value)
+		}
+	}
+	return nil
+}
+
+func setImmunity(guildId, userId, value string) error {
+	_, err := bot.GetGuild(guildId)
+	if err != nil {
+		return err
+	}
+
+	// Users that aren't tracked yet get tracked from now on
+	_, err = bot.CreateUser(guildId, userId, time.Now().UTC(), bot.SourceManual)
+	if err != nil {
+		return err
+	}
+
+	user, err := bot.GetUser(guildId, userId)
+	if err != nil {
+		return err
+	}
+
+	days, err := strconv.ParseInt(value, 0, 64)
+	if err == nil {
+		until := time.Now().UTC().AddDate(0, 0, int(days))
+		err = user.UpdateImmuneUntil(until)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(userId, "is immune until", until.Format("2006-01-02 15:04"), "UTC")
+		return nil
+	}
+
+	immune := value == "on" || value == "true" || value == "yes"
+	if !immune && value != "off" && value != "false" && value != "no" {
+		return fmt.Errorf("Expected on, off or a number of days, got %s", value)
+	}
+
+	err = user.UpdateImmunity(immune)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(userId, "immunity is set to:", immune)
+	return nil
+}
+
+func purgeGuild(guildId string, confirmed bool) error {
+	_, err := bot.GetGuild(guildId)
+	if err != nil {
+		return err
+	}
+
+	// A running bot would bring the guild and its users back with its next flush or event
+	running, err := bot.BotRunning(time.Now())
+	if err != nil {
+		return err
+	}
+	if running {
+		return errors.New("The bot is still running, stop it before purging. It can take two minutes after stopping until it's seen as stopped")
+	}
+
+	users, err := bot.CountUsers(guildId)
+	if err != nil {
+		return err
+	}
+
+	if !confirmed {
+		fmt.Print("Delete ", guildId, " and its ", users, " tracked users? Type the guild id to confirm: ")
+
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if strings.TrimSpace(answer) != guildId {
+			fmt.Println("Nothing was deleted")
+			return nil
+		}
+	}
+
+	err = bot.PurgeGuild(guildId)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Deleted", guildId, "and its", users, "tracked users")
+	return nil
+}
+
+func dryRun(guildId, ownerId string) error {
+	report, warned, kicked, err := bot.EvaluateGuild(guildId, ownerId)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Checked", report.Checked, "users,", report.Immune, "immune,", report.Errored, "errors")
+	if ownerId == "" {
+		fmt.Println("The owner isn't known without discord, give their id to leave them out")
+	}
+	fmt.Println("The percentage limit is checked against the tracked users, discord's member count isn't known")
+
+	fmt.Println("Would be warned:", len(warned))
+	for _, userId := range warned {
+		fmt.Println("  ", userId)
+	}
+
+	if report.Queued > 0 {
+		fmt.Println("Would be queued for review:", len(kicked))
+	} else {
+		fmt.Println("Would be kicked:", len(kicked))
+	}
+	for _, userId := range kicked {
+		fmt.Println("  ", userId)
+	}
+
+	if report.Capped {
+		fmt.Println("The kick limit would stop the run, nobody would be warned or kicked")
+	}
+	return nil
+}
@@ -3,6 +3,6 @@ module github.com/Member1221/yeetbot
 go 1.14
 
 require (
-	github.com/bwmarrin/discordgo v0.21.1
+	github.com/bwmarrin/discordgo v0.27.1
 	go.mongodb.org/mongo-driver v1.3.4
 )
@@ -1,9 +1,7 @@
 package main
 
 import (
-	"context"
-	"encoding/json"
-	"io/ioutil"
+	"flag"
 	"log"
 	"os"
 	"os/signal"
@@ -12,34 +10,53 @@ import (
 
 	bot "github.com/Member1221/yeetbot/bot"
 	discord "github.com/bwmarrin/discordgo"
-	"go.mongodb.org/mongo-driver/bson"
 )
 
 func main() {
+	dedupe := flag.Bool("dedupe", false, "merge duplicate users and servers in the database, then exit")
+	flag.Parse()
 
 	// Load config json
-	cfgstr, err := ioutil.ReadFile("config.json")
+	config, err := bot.LoadConfig("config.json")
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	// Marshal config json to config struct
-	var config bot.ConfigData
-	err = json.Unmarshal(cfgstr, &config)
+	// Connect to database
+	err = bot.ConnectToDB(config.ConnectionString)
+	defer bot.MongoClient.Disconnect()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	// Connect to database
-	err = bot.ConnectToDB(config.ConnectionString)
-	defer bot.MongoClient.Disconnect()
+	// Bring documents stored by older versions up to date before anything reads them
+	err = bot.RunMigrations()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Maintenance mode stops every automated kick, from the config or the maintenance command
+	bot.BotAdmins = config.Admins
+	err = bot.LoadMaintenance(config.Maintenance)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	// One-off cleanup of data stored before the unique indexes existed
+	if *dedupe {
+		err = bot.Dedupe()
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	// Log on to discord with bot token
 	session, err := discord.New("Bot " + config.Token)
-	session.Identify.Intents = discord.MakeIntent(discord.IntentsAllWithoutPrivileged | discord.IntentsGuildMembers)
+	session.Identify.Intents = discord.MakeIntent(discord.IntentsAllWithoutPrivileged | discord.IntentsGuildMembers | discord.IntentsMessageContent)
+	if config.PresenceIntent {
+		session.Identify.Intents |= discord.IntentsGuildPresences
+	}
 	defer session.Close()
 	if err != nil {
 		log.Fatal(err)
@@ -51,6 +68,9 @@ func main() {
 	session.AddHandler(bot.HandleUserJoin)
 	session.AddHandler(bot.HandleUserLeave)
 	session.AddHandler(bot.HandleUserVoice)
+	session.AddHandler(bot.HandleReaction)
+	session.AddHandler(bot.HandlePresence)
+	session.AddHandler(bot.HandleThreadCreate)
 	session.AddHandler(bot.HandleSelfJoin)
 	session.AddHandler(bot.HandleSelfLeave)
 
@@ -66,13 +86,27 @@ func main() {
 		// Get its ID
 		bot.SelfId = self.ID
 
+		// Make sure the time the bot was offline doesn't count as inactivity
+		// This has to happen before any kicks do
+		downtime, err := bot.HandleOutage()
+		if err != nil {
+			log.Println(err)
+		}
+		bot.StartHeartbeat()
+		bot.StartActivityFlush()
+
+		// Pick up the messages that were sent while the bot was offline
+		if downtime > 0 {
+			go bot.BackfillAfterOutage(s, time.Now().UTC().Add(-downtime))
+		}
+
 		// Scan servers
 		log.Println("Scanning for missed servers...")
 		scanServers(s)
 
 		// Begin the loop that occasionally kicks inactive people
 		log.Println("Bot started...")
-		go updateServers(s)
+		bot.StartScheduler(s)
 	})
 
 	// Connect to discord
@@ -84,6 +118,15 @@ func main() {
 	sc := make(chan os.Signal, 1)
 	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt, os.Kill)
 	<-sc
+
+	// Don't lose the time people spent in voice so far
+	bot.CloseVoiceSessions(session)
+
+	// Write out the activity that hasn't been flushed yet
+	err = bot.FlushActivity()
+	if err != nil {
+		log.Println(err)
+	}
 }
 
 func scanServers(session *discord.Session) {
@@ -106,46 +149,3 @@ func scanServers(session *discord.Session) {
 		}
 	}
 }
-
-func updateServers(session *discord.Session) {
-	for {
-
-		// Update the server count
-		bot.UpdateServerCount(session)
-
-		// Create a cursor over all the servers
-		cur, err := bot.MongoClient.ServersCollection().Find(context.Background(), bson.D{})
-		if err != nil {
-			log.Println(err)
-		} else {
-
-			// No errors, iterate over all servers
-			for cur.Next(context.Background()) {
-
-				var result bot.GuildData
-				err := cur.Decode(&result)
-				if err != nil {
-					log.Println(err)
-					break
-				}
-
-				// Get Discord guild
-				guild, err := session.Guild(result.GuildId)
-				if err != nil {
-					log.Println(err)
-					break
-				}
-
-				// Handle kicking for the guild
-				bot.HandleKickForGuild(session, guild, result)
-
-				// Sleep 1 minute between each server update
-				time.Sleep(1 * time.Minute)
-			}
-		}
-
-		// Update server lisiting every 10 minutes
-		cur.Close(context.Background())
-		time.Sleep(1 * time.Hour)
-	}
-}